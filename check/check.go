@@ -0,0 +1,536 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"wabbit-go/common"
+	"wabbit-go/model"
+)
+
+// error codes of the checker
+const (
+	ErrUndefined     = "E0201" // name or function not defined
+	ErrRedefined     = "E0202" // name defined twice in a scope or a type name reused
+	ErrUnknownType   = "E0203"
+	ErrTypeMismatch  = "E0204"
+	ErrNotAValue     = "E0205" // a type or function used as a value
+	ErrNotCallable   = "E0206"
+	ErrArguments     = "E0207" // wrong number of arguments
+	ErrAssignment    = "E0208" // assignment to a const, function or expression
+	ErrControlFlow   = "E0209" // break, continue or return out of place
+	ErrMissingReturn = "E0210"
+	ErrUnsupported   = "E0211"
+	ErrCharLiteral   = "E0212"
+)
+
+var builtinTypes = []string{"int", "float", "bool", "char"}
+
+// what each conversion function accepts
+var casts = map[string]map[string]bool{
+	"int":   {"int": true, "float": true, "char": true},
+	"float": {"int": true, "float": true, "char": true},
+	"char":  {"int": true, "char": true},
+	"bool":  {"bool": true},
+}
+
+// Symbol is what a name is bound to while checking
+type Symbol struct {
+	Kind   string // "var", "const", "func" or "type"
+	Type   string
+	Params []string
+	Line   int // where it was declared, 0 for builtins
+}
+
+type Function struct {
+	name    string
+	retType string
+}
+
+type Context struct {
+	program     *model.Program
+	env         *common.ChainMap
+	function    *Function
+	loops       int
+	compounds   int // compound expressions around, which can't be left
+	diagnostics []model.Diagnostic
+}
+
+func NewContext(program *model.Program) *Context {
+	universe := common.NewChainMap()
+	for _, name := range builtinTypes {
+		universe.SetValue(name, &Symbol{Kind: "type", Type: name})
+	}
+	for _, f := range program.Host.Functions() {
+		universe.SetValue(f.Name, &Symbol{Kind: "func", Type: f.Result, Params: f.Params})
+	}
+	return &Context{
+		program: program,
+		env:     universe.NewChild(),
+	}
+}
+
+func (ctx *Context) Define(name string, value *Symbol) {
+	ctx.env.SetValue(name, value)
+}
+
+func (ctx *Context) Lookup(name string) *Symbol {
+	v, ok := ctx.env.GetValue(name)
+	if ok {
+		return v.(*Symbol)
+	}
+	return nil
+}
+
+func (ctx *Context) NewScope(do func()) {
+	oldEnv := ctx.env
+	ctx.env = ctx.env.NewChild()
+	defer func() {
+		ctx.env = oldEnv
+	}()
+	do()
+}
+
+func (ctx *Context) Error(node model.Node, code string, format string, args ...interface{}) {
+	ctx.diagnostics = append(ctx.diagnostics, ctx.program.Diagnostic(node, code, format, args...))
+}
+
+// Note adds a note to the last error
+func (ctx *Context) Note(format string, args ...interface{}) {
+	d := &ctx.diagnostics[len(ctx.diagnostics)-1]
+	d.Notes = append(d.Notes, fmt.Sprintf(format, args...))
+}
+
+// CheckProgram verifies types, scopes and control flow of an already parsed program.
+// The diagnostics are also reported to the program.
+func CheckProgram(program *model.Program) []model.Diagnostic {
+	ctx := NewContext(program)
+	CheckNode(program.Model, ctx)
+	for _, d := range ctx.diagnostics {
+		program.Report(d)
+	}
+	return ctx.diagnostics
+}
+
+// Check checks program after the programs checked before with ctx, what they
+// defined is in scope. The definitions of program stay only when it has no errors.
+func (ctx *Context) Check(program *model.Program) []model.Diagnostic {
+	env := ctx.env
+	ctx.program, ctx.diagnostics = program, nil
+	// a scope of its own, what it defines can shadow the programs before
+	ctx.env = env.NewChild()
+	CheckNode(program.Model, ctx)
+	for _, d := range ctx.diagnostics {
+		program.Report(d)
+	}
+	if len(ctx.diagnostics) > 0 {
+		ctx.env = env
+	}
+	return ctx.diagnostics
+}
+
+func isType(name string) bool {
+	for _, t := range builtinTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// accepted lists what the conversion to typ accepts
+func accepted(typ string) string {
+	var types []string
+	for t := range casts[typ] {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// declare binds a var or const in the current scope. node is used for reporting
+func declare(node model.Node, name string, symbol *Symbol, ctx *Context) {
+	if isType(name) {
+		ctx.Error(node, ErrRedefined, "can't use type name '%s' as a name", name)
+		return
+	}
+	if ctx.program.Host.Lookup(name) != nil {
+		// the backends call host functions by name, whatever is in scope
+		ctx.Error(node, ErrRedefined, "can't use host function name '%s' as a name", name)
+		return
+	}
+	if previous, ok := ctx.env.GetLocalValue(name); ok {
+		ctx.Error(node, ErrRedefined, "'%s' already defined", name)
+		ctx.Note("previous definition of '%s' is on line %d", name, previous.(*Symbol).Line)
+		return
+	}
+	symbol.Line = ctx.program.Location(node).Lineno
+	ctx.Define(name, symbol)
+}
+
+// resolveType checks the type written in a declaration, "" means unknown
+func resolveType(node model.Node, typ model.Type, ctx *Context) string {
+	if typ == nil {
+		return ""
+	}
+	if !isType(typ.Type()) {
+		ctx.Error(node, ErrUnknownType, "unknown type '%s'", typ.Type())
+		return ""
+	}
+	return typ.Type()
+}
+
+func checkBinOp(node model.Node, op string, left, right model.Expression, allowed []string, ctx *Context) string {
+	lt := CheckNode(left, ctx)
+	rt := CheckNode(right, ctx)
+	if lt == "" || rt == "" {
+		return ""
+	}
+	if lt != rt {
+		ctx.Error(node, ErrTypeMismatch, "mismatched types %s %s %s", lt, op, rt)
+		return ""
+	}
+	for _, t := range allowed {
+		if t == lt {
+			return lt
+		}
+	}
+	ctx.Error(node, ErrTypeMismatch, "unsupported operator %s for %s", op, lt)
+	return ""
+}
+
+func checkRelOp(node model.Node, op string, left, right model.Expression, allowed []string, ctx *Context) string {
+	if checkBinOp(node, op, left, right, allowed, ctx) == "" {
+		return ""
+	}
+	return "bool"
+}
+
+func checkUnaryOp(node model.Node, op string, operand model.Expression, allowed []string, ctx *Context) string {
+	t := CheckNode(operand, ctx)
+	if t == "" {
+		return ""
+	}
+	for _, a := range allowed {
+		if a == t {
+			return t
+		}
+	}
+	ctx.Error(node, ErrTypeMismatch, "unsupported operator %s for %s", op, t)
+	return ""
+}
+
+func checkTest(node model.Node, test model.Expression, ctx *Context) {
+	t := CheckNode(test, ctx)
+	if t != "" && t != "bool" {
+		ctx.Error(node, ErrTypeMismatch, "condition must be bool, got %s", t)
+	}
+}
+
+// alwaysReturns tells whether every path through the statements ends with return
+func alwaysReturns(statements *model.Statements) bool {
+	for _, statement := range statements.Statements {
+		switch v := statement.(type) {
+		case *model.ReturnStatement:
+			return true
+		case *model.IfStatement:
+			if v.Alternative != nil && alwaysReturns(&v.Consequence) && alwaysReturns(v.Alternative) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var numeric = []string{"int", "float"}
+var ordered = []string{"int", "float", "char"}
+var comparable = []string{"int", "float", "char", "bool"}
+
+// CheckNode checks node and returns the type of the value it produces.
+// "" is returned for statements and for expressions that already failed.
+// The type of every expression is recorded in the program.
+func CheckNode(node model.Node, ctx *Context) string {
+	typ := checkNode(node, ctx)
+	if _, ok := node.(model.Expression); ok && typ != "" {
+		ctx.program.RecordType(node, typ)
+	}
+	return typ
+}
+
+func checkNode(node model.Node, ctx *Context) string {
+	switch v := node.(type) {
+	case *model.Integer:
+		return "int"
+	case *model.Float:
+		return "float"
+	case *model.Character:
+		if value, err := strconv.Unquote(v.Value); err != nil || len(value) != 1 {
+			ctx.Error(v, ErrCharLiteral, "invalid character literal %s", v.Value)
+			ctx.Note("a character literal holds exactly one byte")
+			return ""
+		}
+		return "char"
+	case *model.NameBool:
+		return "bool"
+	case *model.Name:
+		symbol := ctx.Lookup(v.Text)
+		if symbol == nil {
+			ctx.Error(v, ErrUndefined, "undefined name '%s'", v.Text)
+			return ""
+		}
+		if symbol.Kind == "type" {
+			ctx.Error(v, ErrNotAValue, "'%s' is a type, not a value", v.Text)
+			return ""
+		}
+		if symbol.Kind == "func" {
+			ctx.Error(v, ErrNotAValue, "'%s' is a function, not a value", v.Text)
+			return ""
+		}
+		return symbol.Type
+
+	case *model.Add:
+		return checkBinOp(v, "+", v.Left, v.Right, numeric, ctx)
+	case *model.Sub:
+		return checkBinOp(v, "-", v.Left, v.Right, numeric, ctx)
+	case *model.Mul:
+		return checkBinOp(v, "*", v.Left, v.Right, numeric, ctx)
+	case *model.Div:
+		return checkBinOp(v, "/", v.Left, v.Right, numeric, ctx)
+	case *model.Lt:
+		return checkRelOp(v, "<", v.Left, v.Right, ordered, ctx)
+	case *model.Le:
+		return checkRelOp(v, "<=", v.Left, v.Right, ordered, ctx)
+	case *model.Gt:
+		return checkRelOp(v, ">", v.Left, v.Right, ordered, ctx)
+	case *model.Ge:
+		return checkRelOp(v, ">=", v.Left, v.Right, ordered, ctx)
+	case *model.Eq:
+		return checkRelOp(v, "==", v.Left, v.Right, comparable, ctx)
+	case *model.Ne:
+		return checkRelOp(v, "!=", v.Left, v.Right, comparable, ctx)
+	case *model.LogAnd:
+		return checkBinOp(v, "&&", v.Left, v.Right, []string{"bool"}, ctx)
+	case *model.LogOr:
+		return checkBinOp(v, "||", v.Left, v.Right, []string{"bool"}, ctx)
+	case *model.Neg:
+		return checkUnaryOp(v, "-", v.Operand, numeric, ctx)
+	case *model.Pos:
+		return checkUnaryOp(v, "+", v.Operand, numeric, ctx)
+	case *model.Not:
+		return checkUnaryOp(v, "!", v.Operand, []string{"bool"}, ctx)
+	case *model.Grouping:
+		return CheckNode(v.Expression, ctx)
+
+	case *model.Assignment:
+		valtype := CheckNode(v.Value, ctx)
+		name, ok := v.Location.(*model.Name)
+		if !ok {
+			ctx.Error(v, ErrAssignment, "can't assign to an expression")
+			return ""
+		}
+		symbol := ctx.Lookup(name.Text)
+		if symbol == nil {
+			ctx.Error(v, ErrUndefined, "undefined name '%s'", name.Text)
+			return ""
+		}
+		if symbol.Kind != "var" {
+			ctx.Error(v, ErrAssignment, "can't assign to %s '%s'", symbol.Kind, name.Text)
+			return ""
+		}
+		if valtype != "" && valtype != symbol.Type {
+			ctx.Error(v, ErrTypeMismatch, "can't assign %s to '%s' of type %s", valtype, name.Text, symbol.Type)
+			return ""
+		}
+		return symbol.Type
+
+	case *model.VarDeclaration:
+		typ := resolveType(v, v.Type, ctx)
+		if v.Type == nil && v.Value == nil {
+			ctx.Error(v, ErrUnknownType, "variable '%s' needs a type or a value", v.Name.Text)
+			return ""
+		}
+		if v.Value != nil {
+			valtype := CheckNode(v.Value, ctx)
+			if typ == "" {
+				typ = valtype
+			} else if valtype != "" && valtype != typ {
+				ctx.Error(v, ErrTypeMismatch, "can't initialize '%s' of type %s with %s", v.Name.Text, typ, valtype)
+			}
+		}
+		if typ != "" {
+			ctx.program.RecordType(v, typ)
+			declare(v, v.Name.Text, &Symbol{Kind: "var", Type: typ}, ctx)
+		}
+
+	case *model.ConstDeclaration:
+		typ := resolveType(v, v.Type, ctx)
+		valtype := CheckNode(v.Value, ctx)
+		if typ == "" {
+			typ = valtype
+		} else if valtype != "" && valtype != typ {
+			ctx.Error(v, ErrTypeMismatch, "can't initialize '%s' of type %s with %s", v.Name.Text, typ, valtype)
+		}
+		if typ != "" {
+			ctx.program.RecordType(v, typ)
+			declare(v, v.Name.Text, &Symbol{Kind: "const", Type: typ}, ctx)
+		}
+
+	case *model.PrintStatement:
+		CheckNode(v.Value, ctx)
+
+	case *model.ExpressionAsStatement:
+		return CheckNode(v.Expression, ctx)
+
+	case *model.Statements:
+		var result string
+		for _, statement := range v.Statements {
+			result = CheckNode(statement, ctx)
+		}
+		return result
+
+	case *model.CompoundExpression:
+		// loops inside can break, the ones around can't be left from here
+		var val string
+		oldLoops := ctx.loops
+		ctx.loops = 0
+		ctx.compounds++
+		ctx.NewScope(func() {
+			val = CheckNode(&v.Statements, ctx)
+		})
+		ctx.compounds--
+		ctx.loops = oldLoops
+		n := len(v.Statements.Statements)
+		if n == 0 {
+			ctx.Error(v, ErrUnsupported, "compound expression can't be empty")
+			return ""
+		}
+		if _, ok := v.Statements.Statements[n-1].(*model.ExpressionAsStatement); !ok {
+			ctx.Error(v, ErrUnsupported, "compound expression must end with an expression")
+			return ""
+		}
+		return val
+
+	case *model.IfStatement:
+		checkTest(v, v.Test, ctx)
+		ctx.NewScope(func() {
+			CheckNode(&v.Consequence, ctx)
+		})
+		if v.Alternative != nil {
+			ctx.NewScope(func() {
+				CheckNode(v.Alternative, ctx)
+			})
+		}
+
+	case *model.WhileStatement:
+		checkTest(v, v.Test, ctx)
+		ctx.loops++
+		ctx.NewScope(func() {
+			CheckNode(&v.Body, ctx)
+		})
+		ctx.loops--
+
+	case *model.BreakStatement:
+		if ctx.loops == 0 && ctx.compounds > 0 {
+			ctx.Error(v, ErrControlFlow, "break out of a compound expression")
+		} else if ctx.loops == 0 {
+			ctx.Error(v, ErrControlFlow, "break outside of a loop")
+		}
+	case *model.ContinueStatement:
+		if ctx.loops == 0 && ctx.compounds > 0 {
+			ctx.Error(v, ErrControlFlow, "continue out of a compound expression")
+		} else if ctx.loops == 0 {
+			ctx.Error(v, ErrControlFlow, "continue outside of a loop")
+		}
+
+	case *model.ReturnStatement:
+		valtype := CheckNode(v.Value, ctx)
+		if ctx.function == nil {
+			ctx.Error(v, ErrControlFlow, "return outside of a function")
+		} else if ctx.compounds > 0 {
+			ctx.Error(v, ErrControlFlow, "return out of a compound expression")
+		} else if valtype != "" && ctx.function.retType != "" && valtype != ctx.function.retType {
+			ctx.Error(v, ErrTypeMismatch, "function '%s' returns %s, not %s",
+				ctx.function.name, ctx.function.retType, valtype)
+		}
+
+	case *model.FunctionDeclaration:
+		if ctx.function != nil {
+			ctx.Error(v, ErrUnsupported, "nested functions are not supported")
+			return ""
+		}
+		retType := resolveType(v, v.ReturnType, ctx)
+		var params []string
+		for i := range v.Parameters {
+			params = append(params, resolveType(v, v.Parameters[i].Type, ctx))
+		}
+		ctx.program.RecordType(v, retType)
+		declare(v, v.Name.Text, &Symbol{Kind: "func", Type: retType, Params: params}, ctx)
+
+		oldLoops, oldCompounds := ctx.loops, ctx.compounds
+		ctx.function = &Function{name: v.Name.Text, retType: retType}
+		ctx.loops, ctx.compounds = 0, 0
+		ctx.NewScope(func() {
+			for i, param := range v.Parameters {
+				if params[i] != "" {
+					declare(v, param.Name.Text, &Symbol{Kind: "var", Type: params[i]}, ctx)
+				}
+			}
+			CheckNode(&v.Body, ctx)
+		})
+		ctx.function = nil
+		ctx.loops, ctx.compounds = oldLoops, oldCompounds
+		if !alwaysReturns(&v.Body) {
+			ctx.Error(v, ErrMissingReturn, "function '%s' is missing a return statement", v.Name.Text)
+			ctx.Note("every path through the body must end with return")
+		}
+
+	case *model.FunctionApplication:
+		var argTypes []string
+		for _, arg := range v.Arguments {
+			argTypes = append(argTypes, CheckNode(arg, ctx))
+		}
+		name, ok := v.Func.(*model.Name)
+		if !ok {
+			ctx.Error(v, ErrNotCallable, "can't call an expression")
+			return ""
+		}
+		symbol := ctx.Lookup(name.Text)
+		if symbol == nil {
+			ctx.Error(v, ErrUndefined, "undefined function '%s'", name.Text)
+			return ""
+		}
+		switch symbol.Kind {
+		case "type":
+			if len(argTypes) != 1 {
+				ctx.Error(v, ErrArguments, "conversion to %s takes exactly 1 argument, got %d", name.Text, len(argTypes))
+				return ""
+			}
+			if argTypes[0] != "" && !casts[name.Text][argTypes[0]] {
+				ctx.Error(v, ErrTypeMismatch, "can't convert %s to %s", argTypes[0], name.Text)
+				ctx.Note("%s() accepts %s", name.Text, accepted(name.Text))
+			}
+			return name.Text
+		case "func":
+			if len(argTypes) != len(symbol.Params) {
+				ctx.Error(v, ErrArguments, "function '%s' takes %d arguments, got %d", name.Text, len(symbol.Params), len(argTypes))
+				return symbol.Type
+			}
+			for i, argType := range argTypes {
+				if argType != "" && symbol.Params[i] != "" && argType != symbol.Params[i] {
+					ctx.Error(v, ErrTypeMismatch, "argument %d of '%s' must be %s, got %s",
+						i+1, name.Text, symbol.Params[i], argType)
+				}
+			}
+			return symbol.Type
+		default:
+			ctx.Error(v, ErrNotCallable, "'%s' is not a function", name.Text)
+			return ""
+		}
+
+	default:
+		panic(fmt.Sprintf("Can't check %#v", v))
+	}
+
+	return ""
+}
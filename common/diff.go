@@ -0,0 +1,128 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContext = 3
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	text string
+	i, j int // lines of a and b before this edit
+}
+
+// Diff gives the unified diff turning a into b, empty when they are equal
+func Diff(aName, bName string, a, b string) string {
+	if a == b {
+		return ""
+	}
+	x, y := splitLines(a), splitLines(b)
+	edits := diffLines(x, y)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(edits); {
+		// a hunk is a run of changes less than two contexts apart
+		first := start
+		for first < len(edits) && edits[first].op == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for k := first; k < len(edits) && k-last <= 2*diffContext; k++ {
+			if edits[k].op != ' ' {
+				last = k
+			}
+		}
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		if from < 0 {
+			from = 0
+		}
+		to := last + diffContext + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+		writeHunk(&sb, edits[from:to])
+		start = to
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines gives the shortest edit script by the longest common subsequence
+func diffLines(x, y []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+		}
+	}
+	return edits
+}
+
+func writeHunk(sb *strings.Builder, edits []edit) {
+	na, nb := 0, 0
+	for _, e := range edits {
+		if e.op != '+' {
+			na++
+		}
+		if e.op != '-' {
+			nb++
+		}
+	}
+	a, b := edits[0].i, edits[0].j
+	if na > 0 {
+		a++
+	}
+	if nb > 0 {
+		b++
+	}
+	fmt.Fprintf(sb, "@@ -%d,%d +%d,%d @@\n", a, na, b, nb)
+	for _, e := range edits {
+		sb.WriteByte(e.op)
+		sb.WriteString(e.text)
+		if !strings.HasSuffix(e.text, "\n") {
+			sb.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
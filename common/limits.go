@@ -0,0 +1,104 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// The errors stopping a program at a limit of its RunOptions. Backends return
+// them wrapped in the error telling where the program was, use errors.Is.
+var (
+	ErrStepLimit   = errors.New("step limit exceeded")
+	ErrDepthLimit  = errors.New("stack overflow")
+	ErrOutputLimit = errors.New("output limit exceeded")
+	ErrTimeout     = errors.New("time limit exceeded")
+)
+
+// checkEvery is how many steps run between looks at the context and the clock
+const checkEvery = 1024
+
+// Limiter counts the steps of a running program and stops it at MaxSteps,
+// at the Timeout or when its context is done
+type Limiter struct {
+	ctx      context.Context
+	maxSteps int64
+	deadline time.Time
+	steps    int64 // taken before the window
+	window   int64 // steps between two checks
+	left     int64 // of the window
+}
+
+func NewLimiter(ctx context.Context, options *RunOptions) *Limiter {
+	l := &Limiter{ctx: ctx, maxSteps: options.MaxSteps}
+	if options.Timeout > 0 {
+		l.deadline = time.Now().Add(options.Timeout)
+	}
+	l.refill()
+	return l
+}
+
+// Step takes one step, the error says the program must stop
+func (l *Limiter) Step() error {
+	l.left--
+	if l.left < 0 {
+		return l.check()
+	}
+	return nil
+}
+
+// Steps gives the steps taken so far
+func (l *Limiter) Steps() int64 {
+	if l.left < 0 {
+		// stopped in check
+		return l.steps
+	}
+	return l.steps + l.window - l.left
+}
+
+func (l *Limiter) check() error {
+	l.steps += l.window + 1
+	if l.maxSteps > 0 && l.steps > l.maxSteps {
+		return ErrStepLimit
+	}
+	if err := l.ctx.Err(); err != nil {
+		return err
+	}
+	if !l.deadline.IsZero() && time.Now().After(l.deadline) {
+		return ErrTimeout
+	}
+	l.refill()
+	return nil
+}
+
+// refill starts a window, the last one ends at MaxSteps
+func (l *Limiter) refill() {
+	l.window = checkEvery
+	if l.maxSteps > 0 && l.maxSteps-l.steps < l.window {
+		l.window = l.maxSteps - l.steps
+	}
+	l.left = l.window
+}
+
+// LimitWriter fails writes going past max bytes with ErrOutputLimit, what
+// fits is still written
+type LimitWriter struct {
+	W       io.Writer
+	Max     int64
+	written int64
+}
+
+func (w *LimitWriter) Write(p []byte) (int, error) {
+	if left := w.Max - w.written; int64(len(p)) > left {
+		n, err := w.W.Write(p[:left])
+		w.written += int64(n)
+		if err == nil {
+			err = ErrOutputLimit
+		}
+		return n, err
+	}
+	n, err := w.W.Write(p)
+	w.written += int64(n)
+	return n, err
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultMaxDepth is how deep calls nest unless RunOptions say otherwise
+const DefaultMaxDepth = 10000
+
+// RunOptions say how a backend runs a program. Zero values mean the
+// streams of the process and DefaultMaxDepth. A step is a statement, a turn
+// of a loop or a call in the interpreter and an instruction in the WVM.
+// Runtime errors aren't printed, the backends return them.
+type RunOptions struct {
+	Stdout    io.Writer     // what the program prints
+	Stderr    io.Writer     // diagnostics of the runner, runtime errors are returned instead
+	MaxDepth  int           // calls nested deeper are a stack overflow, tail calls don't nest
+	MaxSteps  int64         // steps a program may take, 0 for no limit
+	MaxOutput int64         // bytes a program may print, 0 for no limit
+	Timeout   time.Duration // a program may run, 0 for no limit
+}
+
+// WithDefaults gives the options with the missing ones filled in
+func (o RunOptions) WithDefaults() *RunOptions {
+	if o.MaxDepth <= 0 {
+		o.MaxDepth = DefaultMaxDepth
+	}
+	if o.Stdout == nil {
+		o.Stdout = os.Stdout
+	}
+	if o.Stderr == nil {
+		o.Stderr = os.Stderr
+	}
+	if o.MaxOutput > 0 {
+		o.Stdout = &LimitWriter{W: o.Stdout, Max: o.MaxOutput}
+	}
+	return &o
+}
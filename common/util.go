@@ -2,26 +2,9 @@ package common
 
 import (
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"sync"
 )
 
-func init() {
-	log.SetLevel(log.DebugLevel)
-}
-func SliceToChannel[T any](tokens []T) chan T {
-	tokenChan := make(chan T)
-	log.Debugf("sending tokens %v", tokens)
-	go func() {
-		for _, token := range tokens {
-			log.Debugf("send token is %v", token)
-			tokenChan <- token
-		}
-		close(tokenChan)
-	}()
-	return tokenChan
-}
-
 type ChainMap struct {
 	m      sync.Map
 	parent *ChainMap
@@ -42,6 +25,11 @@ func (cm *ChainMap) GetValue(key interface{}) (value interface{}, ok bool) {
 	return nil, false
 }
 
+// GetLocalValue only looks at this level, not the parents
+func (cm *ChainMap) GetLocalValue(key interface{}) (value interface{}, ok bool) {
+	return cm.m.Load(key)
+}
+
 func (cm *ChainMap) SetValue(key, value interface{}) {
 	cm.m.Store(key, value)
 }
@@ -52,5 +40,10 @@ func (cm *ChainMap) NewChild() *ChainMap {
 
 func (cm *ChainMap) String() string {
 	// interate cm.m
-	return fmt.Sprintf("%v", cm.m)
+	values := map[interface{}]interface{}{}
+	cm.m.Range(func(key, value interface{}) bool {
+		values[key] = value
+		return true
+	})
+	return fmt.Sprintf("%v", values)
 }
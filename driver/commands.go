@@ -0,0 +1,433 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"wabbit-go/common"
+	"wabbit-go/interpreter"
+	"wabbit-go/llvm"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+	"wabbit-go/tokenize"
+	"wabbit-go/wasm"
+	"wabbit-go/wvm"
+)
+
+func init() {
+	register(&Command{Name: "tokens", Usage: "file", Short: "print the tokens of a program", Run: runTokens})
+	register(&Command{Name: "ast", Usage: "file", Short: "print the syntax tree of a program", Run: runAst})
+	register(&Command{Name: "check", Usage: "file", Short: "parse and type check a program", Run: runCheck})
+	register(&Command{Name: "run", Usage: "file", Short: "run a program", Run: runRun, Flags: runFlags})
+	register(&Command{Name: "build", Usage: "file", Short: "compile a program to an executable, a wasm module or a wvm object file", Run: runBuild, Flags: buildFlags})
+	register(&Command{Name: "exec", Usage: "file.wbc", Short: "run a wvm object file", Run: runExec, Flags: limitFlags})
+	register(&Command{Name: "wvm-dis", Usage: "file", Short: "print the wvm bytecode of a program or an object file", Run: runWvmDis})
+	register(&Command{Name: "wvm-as", Usage: "file", Short: "assemble a wvm listing into an object file", Run: runWvmAs})
+	register(&Command{Name: "fmt", Usage: "files", Short: "print programs in the standard format", Run: runFmt, Flags: fmtFlags})
+}
+
+func writeTokens(w io.Writer, lexer *tokenize.Lexer) model.Diagnostics {
+	for {
+		tok := lexer.Next()
+		fmt.Fprintln(w, tok)
+		if tok.Type == "EOF" {
+			return lexer.Diagnostics()
+		}
+	}
+}
+
+func dumpTokens(file string, source string) string {
+	var sb strings.Builder
+	writeTokens(&sb, tokenize.NewStringLexer(file, source))
+	return sb.String()
+}
+
+func runTokens(inv *Invocation) error {
+	filename, err := inv.File()
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	out, err := inv.Out()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	lexer := tokenize.NewLexer(filename, file)
+	if diagnostics := writeTokens(out, lexer); len(diagnostics) > 0 {
+		return inv.report(diagnostics, lexer.Source())
+	}
+	return nil
+}
+
+func runAst(inv *Invocation) error {
+	program, err := inv.parse()
+	if err != nil {
+		return err
+	}
+	out, err := inv.Out()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.WriteString(out, model.DumpNode(program.Model, program))
+	return err
+}
+
+func runCheck(inv *Invocation) error {
+	_, err := inv.load()
+	return err
+}
+
+func fmtFlags(fs *flag.FlagSet, inv *Invocation) {
+	fs.BoolVarP(&inv.Write, "write", "w", false, "write the result to the file instead of printing it")
+	fs.BoolVar(&inv.Check, "check", false, "print the files that aren't formatted and fail if there are any")
+	fs.BoolVarP(&inv.Diff, "diff", "d", false, "print the changes instead of the result")
+}
+
+func runFmt(inv *Invocation) error {
+	if len(inv.Args) == 0 {
+		return fmt.Errorf("expected input files")
+	}
+	unformatted := false
+	for _, file := range inv.Args {
+		program, err := model.ProgramFromFile(file)
+		if err != nil {
+			return err
+		}
+		if err := parser.ParseProgram(program); err != nil {
+			return inv.report(err, program.Source)
+		}
+		formatted := model.Format(program)
+		changed := formatted != program.Source
+		unformatted = unformatted || changed
+		switch {
+		case inv.Check || inv.Diff:
+			if inv.Check && changed {
+				fmt.Fprintln(inv.Stdout, file)
+			}
+			if inv.Diff {
+				fmt.Fprint(inv.Stdout, common.Diff(file, file, program.Source, formatted))
+			}
+		case inv.Write:
+			if changed {
+				if err := os.WriteFile(file, []byte(formatted), 0644); err != nil {
+					return err
+				}
+			}
+		default:
+			out, err := inv.Out()
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(out, formatted)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if inv.Check && unformatted {
+		return errReported
+	}
+	return nil
+}
+
+func runFlags(fs *flag.FlagSet, inv *Invocation) {
+	fs.StringVarP(&inv.Backend, "backend", "b", "interp", "backend to run with: interp, wvm, wasm or llvm")
+	fs.StringVar(&inv.Engine, "engine", "tree", "how interp runs: tree walks the resolved tree, closure compiles it to closures first")
+	limitFlags(fs, inv)
+	fs.StringVar(&inv.Runtime, "runtime", "llvm/runtime/runtime.c", "C runtime linked into llvm executables")
+}
+
+func limitFlags(fs *flag.FlagSet, inv *Invocation) {
+	fs.IntVar(&inv.MaxDepth, "max-depth", common.DefaultMaxDepth, "calls interp and wvm let nest before a stack overflow")
+	fs.Int64Var(&inv.MaxSteps, "max-steps", 0, "steps interp and wvm let a program take, 0 for no limit")
+	fs.Int64Var(&inv.MaxOutput, "max-output", 0, "bytes interp and wvm let a program print, 0 for no limit")
+	fs.DurationVar(&inv.Timeout, "timeout", 0, "time interp and wvm let a program run, 0 for no limit")
+}
+
+func buildFlags(fs *flag.FlagSet, inv *Invocation) {
+	fs.StringVarP(&inv.Target, "target", "t", "llvm", "what to build: llvm for an executable, wasm for a module, wvm for an object file")
+	fs.StringVar(&inv.Runtime, "runtime", "llvm/runtime/runtime.c", "C runtime linked into llvm executables")
+	fs.BoolVar(&inv.Strip, "strip", false, "leave the line table out of wvm object files, runtime errors lose their lines")
+}
+
+func runRun(inv *Invocation) error {
+	program, err := inv.load()
+	if err != nil {
+		return err
+	}
+	switch inv.Backend {
+	case "interp":
+		switch inv.Engine {
+		case "tree":
+			return inv.stopped(interpreter.InterpretProgram(program, inv.runOptions()), program.Source)
+		case "closure":
+			return inv.stopped(interpreter.CompileProgram(program, inv.runOptions()), program.Source)
+		}
+		return fmt.Errorf("unknown engine %q", inv.Engine)
+	case "wvm":
+		return inv.stopped(wvm.Wvm(program, inv.runOptions()), program.Source)
+	case "wasm", "llvm":
+		// compiled backends build into a scratch directory unless -o says where
+		dir, err := os.MkdirTemp("", "wabbit")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if inv.Backend == "wasm" {
+			return inv.runWasm(program, dir)
+		}
+		return inv.runLLVM(program, dir)
+	}
+	return fmt.Errorf("unknown backend %q", inv.Backend)
+}
+
+func runBuild(inv *Invocation) error {
+	program, err := inv.load()
+	if err != nil {
+		return err
+	}
+	stem := strings.TrimSuffix(inv.Args[0], filepath.Ext(inv.Args[0]))
+	switch inv.Target {
+	case "llvm":
+		return inv.buildLLVM(program, inv.output(stem))
+	case "wasm":
+		return inv.buildWasm(program, inv.output(stem+".wasm"))
+	case "wvm":
+		return inv.buildWvm(program, inv.output(stem+".wbc"))
+	}
+	return fmt.Errorf("unknown target %q", inv.Target)
+}
+
+func (inv *Invocation) buildWvm(program *model.Program, out string) error {
+	bytecode, err := wvm.Compile(program)
+	if err != nil {
+		return err
+	}
+	if inv.Strip {
+		bytecode.Strip()
+	}
+	object, err := bytecode.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, object, 0644)
+}
+
+// runExec runs an object file of build --target=wvm, without its source
+func runExec(inv *Invocation) error {
+	file, err := inv.File()
+	if err != nil {
+		return err
+	}
+	bytecode, err := wvm.ReadObjectFile(file)
+	if err != nil {
+		return err
+	}
+	return inv.stopped(wvm.Exec(context.Background(), bytecode, nil, inv.runOptions()), "")
+}
+
+// runWvmDis lists an object file, or the bytecode a program compiles to
+// with its source lines
+func runWvmDis(inv *Invocation) error {
+	file, err := inv.File()
+	if err != nil {
+		return err
+	}
+	var bytecode *wvm.Bytecode
+	source := ""
+	if filepath.Ext(file) == ".wbc" {
+		bytecode, err = wvm.ReadObjectFile(file)
+	} else {
+		var program *model.Program
+		if program, err = inv.load(); err != nil {
+			return err
+		}
+		source = program.Source
+		bytecode, err = wvm.Compile(program)
+	}
+	if err != nil {
+		return err
+	}
+	out, err := inv.Out()
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.WriteString(out, wvm.Disassemble(bytecode, source))
+	return err
+}
+
+// runWvmAs writes the object file of a listing like wvm-dis prints
+func runWvmAs(inv *Invocation) error {
+	file, err := inv.File()
+	if err != nil {
+		return err
+	}
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	bytecode, err := wvm.AssembleText(string(text))
+	if err == nil {
+		err = bytecode.Verify()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	object, err := bytecode.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(inv.output(strings.TrimSuffix(file, filepath.Ext(file))+".wbc"), object, 0644)
+}
+
+// stopped prints the runtime error a program stopped with, with its line and stack
+func (inv *Invocation) stopped(err error, source string) error {
+	var runtimeError *model.RuntimeError
+	if errors.As(err, &runtimeError) {
+		return inv.report(runtimeError, source)
+	}
+	return err
+}
+
+// runOptions make the in-process backends print where the command does
+func (inv *Invocation) runOptions() common.RunOptions {
+	return common.RunOptions{
+		Stdout:    inv.Stdout,
+		Stderr:    inv.Stderr,
+		MaxDepth:  inv.MaxDepth,
+		MaxSteps:  inv.MaxSteps,
+		MaxOutput: inv.MaxOutput,
+		Timeout:   inv.Timeout,
+	}
+}
+
+// output gives -o, or def when it wasn't given
+func (inv *Invocation) output(def string) string {
+	if inv.Output != "" {
+		return inv.Output
+	}
+	return def
+}
+
+// command runs an external tool, its output goes to ours
+func (inv *Invocation) command(name string, args ...string) error {
+	log.Debugf("%s %s", name, strings.Join(args, " "))
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = inv.Stdout
+	cmd.Stderr = inv.Stderr
+	return cmd.Run()
+}
+
+func (inv *Invocation) buildLLVM(program *model.Program, out string) error {
+	code := llvm.LLVM(program)
+	if err := inv.emit("ll", func() string { return code }); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "wabbit")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	ll := filepath.Join(dir, "out.ll")
+	if err := os.WriteFile(ll, []byte(code), 0644); err != nil {
+		return err
+	}
+	if err := inv.command("clang", "-O3", "-o", out, inv.Runtime, ll); err != nil {
+		return fmt.Errorf("clang: %v", err)
+	}
+	return nil
+}
+
+func (inv *Invocation) runLLVM(program *model.Program, dir string) error {
+	exe := inv.output(filepath.Join(dir, "a.out"))
+	if err := inv.buildLLVM(program, exe); err != nil {
+		return err
+	}
+	if !filepath.IsAbs(exe) && !strings.ContainsRune(exe, filepath.Separator) {
+		exe = "." + string(filepath.Separator) + exe
+	}
+	return inv.command(exe)
+}
+
+// wat2wasm of the npm wabt package when it is installed, else from PATH
+func wat2wasm() string {
+	local := filepath.Join("node_modules", "wabt", "bin", "wat2wasm")
+	if _, err := os.Stat(local); err == nil {
+		return local
+	}
+	return "wat2wasm"
+}
+
+func (inv *Invocation) buildWasm(program *model.Program, out string) error {
+	code := wasm.Wasm(program)
+	if err := inv.emit("wat", func() string { return code }); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "wabbit")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	wat := filepath.Join(dir, "out.wat")
+	if err := os.WriteFile(wat, []byte(code), 0644); err != nil {
+		return err
+	}
+	if err := inv.command(wat2wasm(), "--enable-tail-call", "-o", out, wat); err != nil {
+		return fmt.Errorf("wat2wasm: %v", err)
+	}
+	return nil
+}
+
+// wasmRunner is test.js taking the module from the command line
+const wasmRunner = `const fs = require('fs');
+const bytes = fs.readFileSync(process.argv[2]);
+const goFloat = (x) => {
+    // print floats the way Go's fmt.Println does, like the other backends
+    const [m, e] = x.toExponential().split('e');
+    const exp = Number(e);
+    if (exp < -4 || exp >= 6) {
+        return m + 'e' + (exp < 0 ? '-' : '+') + String(Math.abs(exp)).padStart(2, '0');
+    }
+    return String(x);
+};
+let importObject = {
+    env: {
+        _printi: (x) => { console.log(x); },
+        _printf: (x) => { console.log(goFloat(x)); },
+        _printb: (x) => { console.log(x===1); },
+        _printc: (x) => { process.stdout.write(String.fromCharCode(x)); },
+    },
+};
+(async () => {
+    const obj = await WebAssembly.instantiate(new Uint8Array(bytes), importObject);
+    obj.instance.exports.main();
+})();
+`
+
+func (inv *Invocation) runWasm(program *model.Program, dir string) error {
+	module := inv.output(filepath.Join(dir, "out.wasm"))
+	if err := inv.buildWasm(program, module); err != nil {
+		return err
+	}
+	runner := filepath.Join(dir, "run.js")
+	if err := os.WriteFile(runner, []byte(wasmRunner), 0644); err != nil {
+		return err
+	}
+	return inv.command("node", "--experimental-wasm-return_call", runner, module)
+}
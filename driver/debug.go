@@ -0,0 +1,211 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/peterh/liner"
+	flag "github.com/spf13/pflag"
+	"io"
+	"strconv"
+	"strings"
+	"wabbit-go/interpreter"
+	"wabbit-go/model"
+)
+
+func init() {
+	register(&Command{Name: "debug", Usage: "file", Short: "run a program statement by statement", Run: runDebug, Flags: debugFlags})
+}
+
+const debugHelp = `The program stops at every breakpoint, and before its first statement
+when it was started without any.
+
+  break N, b N      stop before the statements of line N
+  delete N, d N     remove the breakpoint of line N, every one without N
+  continue, c       run to the next breakpoint
+  step, s           run to the next statement, into calls
+  next, n           run to the next statement of this call
+  finish, f         run until this call returns
+  print x, p x      print the variable x, every variable in scope without x
+  backtrace, bt     print the calls running
+  list, l           print the lines around the statement
+  quit, q           stop the program, like end of input
+
+An empty line repeats the last command.
+`
+
+// errQuit stops a program being debugged
+var errQuit = errors.New("quit")
+
+// Debugger stops a program run by the interpreter at breakpoints and steps,
+// and runs the commands read in between
+type Debugger struct {
+	in          lineReader
+	out         io.Writer
+	program     *model.Program
+	lines       []string
+	breakpoints map[int]bool
+	mode        string // what stops the program next: step, next, finish or continue
+	depth       int    // of the call next and finish started in
+	last        string // command, repeated by an empty line
+}
+
+// NewDebugger debugs program with the commands read from in
+func NewDebugger(program *model.Program, in io.Reader, out io.Writer) *Debugger {
+	return newDebugger(program, scanner{bufio.NewScanner(in)}, out)
+}
+
+func newDebugger(program *model.Program, in lineReader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:          in,
+		out:         out,
+		program:     program,
+		lines:       strings.Split(program.Source, "\n"),
+		breakpoints: map[int]bool{},
+		mode:        "step",
+	}
+}
+
+// Break sets a breakpoint at line
+func (d *Debugger) Break(line int) error {
+	if line < 1 || line > len(d.lines) {
+		return fmt.Errorf("no line %d", line)
+	}
+	d.breakpoints[line] = true
+	return nil
+}
+
+// Statement stops before the statement at loc when it should and reads commands
+func (d *Debugger) Statement(c *interpreter.Context, loc model.Locator) error {
+	line := loc.StartPos.Line
+	switch {
+	case d.breakpoints[line]:
+	case d.mode == "step":
+	case d.mode == "next" && c.Depth() <= d.depth:
+	case d.mode == "finish" && c.Depth() < d.depth:
+	default:
+		return nil
+	}
+	d.where(line)
+	for {
+		command, err := d.in.Prompt("(wdb) ")
+		switch {
+		case errors.Is(err, liner.ErrPromptAborted):
+			continue
+		case errors.Is(err, io.EOF):
+			return errQuit
+		case err != nil:
+			return err
+		}
+		command = strings.TrimSpace(command)
+		if command == "" {
+			command = d.last
+		}
+		d.last = command
+		if resume, err := d.command(c, command, line); resume || err != nil {
+			return err
+		}
+	}
+}
+
+// command runs command at line, resume says the program goes on
+func (d *Debugger) command(c *interpreter.Context, command string, line int) (resume bool, err error) {
+	name, arg, _ := strings.Cut(command, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "":
+	case "break", "b", "delete", "d":
+		if arg == "" && (name == "delete" || name == "d") {
+			d.breakpoints = map[int]bool{}
+			break
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(d.out, "%s needs a line number\n", name)
+			break
+		}
+		if name == "delete" || name == "d" {
+			delete(d.breakpoints, n)
+		} else if err := d.Break(n); err != nil {
+			fmt.Fprintln(d.out, err)
+		}
+	case "continue", "c":
+		d.mode = "continue"
+		return true, nil
+	case "step", "s", "next", "n", "finish", "f":
+		d.mode = map[string]string{"s": "step", "n": "next", "f": "finish"}[name]
+		if d.mode == "" {
+			d.mode = name
+		}
+		d.depth = c.Depth()
+		return true, nil
+	case "print", "p":
+		vars := c.Variables()
+		if arg != "" {
+			v, ok := c.Lookup(arg)
+			if !ok {
+				fmt.Fprintf(d.out, "no variable '%s' here\n", arg)
+				break
+			}
+			vars = []interpreter.Variable{v}
+		}
+		for _, v := range vars {
+			fmt.Fprintf(d.out, "%s %s = %s\n", v.Name, v.Type, v.Value.Format(v.Type))
+		}
+	case "backtrace", "bt":
+		for i, frame := range c.Stack() {
+			fmt.Fprintf(d.out, "#%d %s at line %d\n", i, frame.Function, frame.Loc.StartPos.Line)
+		}
+	case "list", "l":
+		for n := line - 3; n <= line+3; n++ {
+			if n < 1 || n > len(d.lines) {
+				continue
+			}
+			mark := "  "
+			if n == line {
+				mark = "=>"
+			}
+			fmt.Fprintf(d.out, "%s %3d | %s\n", mark, n, d.lines[n-1])
+		}
+	case "quit", "q":
+		return true, errQuit
+	case "help", "h":
+		fmt.Fprint(d.out, debugHelp)
+	default:
+		fmt.Fprintf(d.out, "unknown command %s, help lists them\n", name)
+	}
+	return false, nil
+}
+
+// where prints the line the program stopped at
+func (d *Debugger) where(line int) {
+	fmt.Fprintf(d.out, "%s:%d: %s\n", model.FileName(d.program.File), line, strings.TrimSpace(d.lines[line-1]))
+}
+
+func debugFlags(fs *flag.FlagSet, inv *Invocation) {
+	fs.IntSliceVarP(&inv.Breakpoints, "break", "b", nil, "lines to stop at, the program runs to the first one instead of stopping before its first statement")
+}
+
+func runDebug(inv *Invocation) error {
+	program, err := inv.load()
+	if err != nil {
+		return err
+	}
+	in, done := inv.lines()
+	defer done()
+	d := newDebugger(program, in, inv.Stdout)
+	for _, line := range inv.Breakpoints {
+		if err := d.Break(line); err != nil {
+			return err
+		}
+		d.mode = "continue"
+	}
+	c := interpreter.NewContext(context.Background(), program, inv.runOptions())
+	c.SetDebugger(d)
+	_, err = c.Run(program)
+	if errors.Is(err, errQuit) {
+		return nil
+	}
+	return inv.stopped(err, program.Source)
+}
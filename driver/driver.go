@@ -0,0 +1,220 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	flag "github.com/spf13/pflag"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"wabbit-go/check"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+)
+
+// Command is a subcommand of the wabbit driver
+type Command struct {
+	Name  string
+	Usage string // arguments, shown in the usage line
+	Short string
+	Run   func(cmd *Invocation) error
+	Flags func(fs *flag.FlagSet, cmd *Invocation)
+}
+
+// Invocation is one run of a command: its parsed flags and where it writes
+type Invocation struct {
+	*Command
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Output      string   // -o, where the product of the command goes
+	LogLevel    string   // -l
+	Emit        []string // intermediate artifacts to keep
+	Backend     string
+	Engine      string // how the interp backend runs: tree or closure
+	MaxDepth    int    // limits of the in-process backends
+	MaxSteps    int64
+	MaxOutput   int64
+	Timeout     time.Duration
+	Target      string
+	Runtime     string // C runtime linked by the llvm backend
+	Write       bool   // fmt rewrites the files
+	Check       bool   // fmt only tells which files would change
+	Diff        bool   // fmt prints the changes
+	Strip       bool   // build leaves the line table out of wvm object files
+	Breakpoints []int  // lines debug stops at
+}
+
+// errReported is returned by commands that already printed their errors
+var errReported = errors.New("errors reported")
+
+var commands = map[string]*Command{}
+
+func register(cmd *Command) {
+	commands[cmd.Name] = cmd
+}
+
+func Usage(w io.Writer) {
+	fmt.Fprint(w, "Usage: wabbit <command> [flags] [file]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].Short)
+	}
+	fmt.Fprint(w, "\nRun 'wabbit <command> --help' for the flags of a command.\n")
+}
+
+// Main runs the driver with the arguments after the program name and gives
+// the exit status: 0 on success, 1 when the program has errors, 2 on misuse.
+func Main(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		Usage(stdout)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "wabbit: unknown command %q\n", args[0])
+		Usage(stderr)
+		return 2
+	}
+	inv := &Invocation{Command: cmd, Stdin: os.Stdin, Stdout: stdout, Stderr: stderr}
+	fs := flag.NewFlagSet("wabbit "+cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: wabbit %s [flags] %s\n\n%s\n\nFlags:\n", cmd.Name, cmd.Usage, cmd.Short)
+		fs.PrintDefaults()
+	}
+	fs.StringVarP(&inv.Output, "output", "o", "", "write the output to this file")
+	fs.StringVarP(&inv.LogLevel, "log-level", "l", "warn", "log level: error, warn, info, debug or trace")
+	fs.StringSliceVar(&inv.Emit, "emit", nil, "keep intermediate artifacts next to the input: tokens, ast, wat, ll")
+	if cmd.Flags != nil {
+		cmd.Flags(fs, inv)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	inv.Args = fs.Args()
+
+	level, err := log.ParseLevel(inv.LogLevel)
+	if err != nil {
+		fmt.Fprintf(stderr, "wabbit: %v\n", err)
+		return 2
+	}
+	log.SetLevel(level)
+	for _, kind := range inv.Emit {
+		if !artifacts[kind] {
+			fmt.Fprintf(stderr, "wabbit: unknown artifact %q for --emit\n", kind)
+			return 2
+		}
+	}
+
+	if err := cmd.Run(inv); err != nil {
+		if err != errReported {
+			fmt.Fprintf(stderr, "wabbit %s: %v\n", cmd.Name, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// File gives the single input file of the command
+func (inv *Invocation) File() (string, error) {
+	if len(inv.Args) != 1 {
+		return "", fmt.Errorf("expected one input file, got %d", len(inv.Args))
+	}
+	return inv.Args[0], nil
+}
+
+// Out gives where the command writes its text output, -o or stdout
+func (inv *Invocation) Out() (io.WriteCloser, error) {
+	if inv.Output == "" || inv.Output == "-" {
+		return nopCloser{inv.Stdout}, nil
+	}
+	return os.Create(inv.Output)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+var artifacts = map[string]bool{"tokens": true, "ast": true, "wat": true, "ll": true}
+
+func (inv *Invocation) emits(kind string) bool {
+	for _, k := range inv.Emit {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// artifact gives the file an intermediate artifact of kind is kept in: the
+// input file with its extension replaced
+func (inv *Invocation) artifact(kind string) string {
+	file := inv.Args[0]
+	return strings.TrimSuffix(file, filepath.Ext(file)) + "." + kind
+}
+
+// emit writes an intermediate artifact when it was asked for
+func (inv *Invocation) emit(kind string, content func() string) error {
+	if !inv.emits(kind) {
+		return nil
+	}
+	log.Debugf("writing %s", inv.artifact(kind))
+	return os.WriteFile(inv.artifact(kind), []byte(content()), 0644)
+}
+
+// report prints the diagnostics of err against source
+func (inv *Invocation) report(err error, source string) error {
+	fmt.Fprint(inv.Stderr, model.RenderError(err, source))
+	return errReported
+}
+
+// parse reads and parses the input file
+func (inv *Invocation) parse() (*model.Program, error) {
+	file, err := inv.File()
+	if err != nil {
+		return nil, err
+	}
+	program, err := model.ProgramFromFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := inv.emit("tokens", func() string { return dumpTokens(program.File, program.Source) }); err != nil {
+		return nil, err
+	}
+	if err := parser.ParseProgram(program); err != nil {
+		return program, inv.report(err, program.Source)
+	}
+	if err := inv.emit("ast", func() string { return model.DumpNode(program.Model, program) }); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// load parses and checks the input file
+func (inv *Invocation) load() (*model.Program, error) {
+	program, err := inv.parse()
+	if err != nil {
+		return program, err
+	}
+	if diagnostics := check.CheckProgram(program); len(diagnostics) > 0 {
+		return program, inv.report(model.Diagnostics(diagnostics), program.Source)
+	}
+	return program, nil
+}
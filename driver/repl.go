@@ -0,0 +1,339 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/peterh/liner"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"wabbit-go/check"
+	"wabbit-go/common"
+	"wabbit-go/interpreter"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+	"wabbit-go/tokenize"
+	"wabbit-go/wvm"
+)
+
+func init() {
+	register(&Command{Name: "repl", Short: "run statements as they are typed", Run: runRepl})
+}
+
+const replHelp = `Statements run as soon as their braces are balanced, the value of an
+expression is printed. The ; ending a single statement can be left out.
+
+  :type expr   print the type of expr
+  :ast expr    print the syntax tree of expr
+  :wvm expr    print the WVM instructions of expr
+  :help        print this
+  :quit        leave, like end of input
+`
+
+// Repl runs inputs one after the other, what they define stays for the next ones
+type Repl struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	session *model.Program // holds the locations and types of the nodes of every input
+	checker *check.Context
+	context *interpreter.Context
+	wvm     *wvm.Context // only has the names defined, for :wvm
+	pending string       // lines of an input not complete yet
+}
+
+func NewRepl(stdout, stderr io.Writer, options common.RunOptions) *Repl {
+	options.Stdout, options.Stderr = stdout, stderr
+	session := model.NewProgram("")
+	session.File = "<repl>"
+	return &Repl{
+		stdout:  stdout,
+		stderr:  stderr,
+		session: session,
+		checker: check.NewContext(session),
+		context: interpreter.NewContext(context.Background(), session, options),
+		wvm:     wvm.NewWVMContext(session),
+	}
+}
+
+// Waiting tells whether the lines so far need more to be complete
+func (r *Repl) Waiting() bool {
+	return r.pending != ""
+}
+
+// Line takes a line typed in and runs the input when it is complete
+func (r *Repl) Line(line string) {
+	r.pending += line + "\n"
+	if !complete(r.pending) {
+		return
+	}
+	input := strings.TrimSpace(r.pending)
+	r.pending = ""
+	if input != "" {
+		r.Eval(input)
+	}
+}
+
+// complete tells whether input has balanced braces and no open comment
+func complete(input string) bool {
+	if strings.HasPrefix(strings.TrimSpace(input), ":") {
+		return true
+	}
+	lexer := tokenize.NewStringLexer("", input)
+	depth := 0
+	for tok := lexer.Next(); tok.Type != "EOF"; tok = lexer.Next() {
+		switch tok.Type {
+		case "LBRACE":
+			depth++
+		case "RBRACE":
+			depth--
+		}
+	}
+	for _, d := range lexer.Diagnostics() {
+		if d.Code == tokenize.ErrUnterminatedComment {
+			return false
+		}
+	}
+	return depth <= 0
+}
+
+// Eval runs a complete input, statements or a command
+func (r *Repl) Eval(input string) {
+	if strings.HasPrefix(input, ":") {
+		command, arg, _ := strings.Cut(input, " ")
+		r.command(command, strings.TrimSpace(arg))
+		return
+	}
+	program, ok := r.load(terminated(input, true))
+	if !ok {
+		return
+	}
+	statements := program.Model.(*model.Statements).Statements
+	if len(statements) == 0 {
+		// just a comment
+		return
+	}
+	// the WVM knows the names the checker does, even when running fails
+	wvm.Declare(program.Model, r.wvm)
+	result, err := r.context.Run(program)
+	if err != nil {
+		fmt.Fprint(r.stderr, model.RenderError(err, program.Source))
+		return
+	}
+	if last, ok := statements[len(statements)-1].(*model.ExpressionAsStatement); ok && !assignment(last.Expression) {
+		fmt.Fprintln(r.stdout, result.Format(program.TypeOf(last.Expression)))
+	}
+}
+
+// terminated adds the ; the last statement of input may leave out, right
+// after its last token and before any comment after it. A } ends a
+// statement too when blocks is set.
+func terminated(input string, blocks bool) string {
+	lexer := tokenize.NewStringLexer("", input)
+	var last tokenize.Token
+	for tok := lexer.Next(); tok.Type != "EOF"; tok = lexer.Next() {
+		last = tok
+	}
+	switch {
+	case last.Type == "" || last.Type == "SEMI":
+		return input
+	case last.Type == "RBRACE" && blocks:
+		return input
+	}
+	return input[:last.End.Offset] + ";" + input[last.End.Offset:]
+}
+
+// assignment tells whether expr is an assignment, its value isn't printed
+func assignment(expr model.Expression) bool {
+	_, ok := expr.(*model.Assignment)
+	return ok
+}
+
+func (r *Repl) command(command string, arg string) {
+	switch command {
+	case ":type", ":ast", ":wvm":
+		if arg == "" {
+			fmt.Fprintf(r.stderr, "%s needs an expression\n", command)
+			return
+		}
+	}
+	switch command {
+	case ":help":
+		fmt.Fprint(r.stdout, replHelp)
+	case ":type":
+		if program, expr, ok := r.expression(arg); ok {
+			fmt.Fprintln(r.stdout, program.TypeOf(expr))
+		}
+	case ":ast":
+		program, err := r.parse(terminated(arg, false))
+		if err != nil {
+			return
+		}
+		statements := program.Model.(*model.Statements).Statements
+		for _, statement := range statements {
+			node := model.Node(statement)
+			if v, ok := statement.(*model.ExpressionAsStatement); ok && len(statements) == 1 {
+				node = v.Expression
+			}
+			fmt.Fprint(r.stdout, model.DumpNode(node, program))
+		}
+	case ":wvm":
+		if _, expr, ok := r.expression(arg); ok {
+			listing := r.wvm.Fork()
+			wvm.InterpretNode(expr, listing)
+			for _, instruction := range listing.Code() {
+				fmt.Fprintln(r.stdout, instruction)
+			}
+		}
+	default:
+		fmt.Fprintf(r.stderr, "unknown command %s, :help lists them\n", command)
+	}
+}
+
+// parse parses source as an input of the session
+func (r *Repl) parse(source string) (*model.Program, error) {
+	program := model.NewProgram(source)
+	program.File = r.session.File
+	program.Db, program.Types = r.session.Db, r.session.Types
+	if err := parser.ParseProgram(program); err != nil {
+		fmt.Fprint(r.stderr, model.RenderError(err, source))
+		return nil, err
+	}
+	return program, nil
+}
+
+// load parses and checks source
+func (r *Repl) load(source string) (*model.Program, bool) {
+	program, err := r.parse(source)
+	if err != nil {
+		return nil, false
+	}
+	if diagnostics := r.checker.Check(program); len(diagnostics) > 0 {
+		fmt.Fprint(r.stderr, model.RenderError(model.Diagnostics(diagnostics), source))
+		return nil, false
+	}
+	return program, true
+}
+
+// expression loads source, which must be a single expression
+func (r *Repl) expression(source string) (*model.Program, model.Expression, bool) {
+	program, ok := r.load(terminated(source, false))
+	if !ok {
+		return nil, nil, false
+	}
+	statements := program.Model.(*model.Statements).Statements
+	if len(statements) == 1 {
+		if v, ok := statements[0].(*model.ExpressionAsStatement); ok {
+			return program, v.Expression, true
+		}
+	}
+	fmt.Fprintf(r.stderr, "%s is not an expression\n", source)
+	return nil, nil, false
+}
+
+// lineReader gives the lines typed in, liner.State is one
+type lineReader interface {
+	Prompt(prompt string) (string, error)
+}
+
+// scanner reads lines without prompts or editing, when the input isn't a terminal
+type scanner struct {
+	*bufio.Scanner
+}
+
+func (s scanner) Prompt(string) (string, error) {
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.Text(), nil
+}
+
+func terminal(in io.Reader) bool {
+	file, ok := in.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func historyFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".wabbit_history")
+}
+
+// lines gives the lines of the input, edited with history when it is a
+// terminal. done saves the history.
+func (inv *Invocation) lines() (in lineReader, done func()) {
+	if !terminal(inv.Stdin) {
+		return scanner{bufio.NewScanner(inv.Stdin)}, func() {}
+	}
+	line := liner.NewLiner()
+	line.SetCtrlCAborts(true)
+	history := historyFile()
+	if f, err := os.Open(history); err == nil {
+		line.ReadHistory(f)
+		f.Close()
+	}
+	return historyReader{line}, func() {
+		if f, err := os.Create(history); err == nil {
+			line.WriteHistory(f)
+			f.Close()
+		}
+		line.Close()
+	}
+}
+
+func runRepl(inv *Invocation) error {
+	if len(inv.Args) > 0 {
+		return fmt.Errorf("repl takes no files")
+	}
+	repl := NewRepl(inv.Stdout, inv.Stderr, inv.runOptions())
+	in, done := inv.lines()
+	defer done()
+	if terminal(inv.Stdin) {
+		fmt.Fprintln(inv.Stdout, "Wabbit, :help for help")
+	}
+	for {
+		prompt := "wabbit> "
+		if repl.Waiting() {
+			prompt = "   ...> "
+		}
+		line, err := in.Prompt(prompt)
+		switch {
+		case errors.Is(err, liner.ErrPromptAborted):
+			// ctrl-c drops the input being typed
+			repl.pending = ""
+			continue
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return err
+		}
+		if command := strings.TrimSpace(line); !repl.Waiting() && (command == ":quit" || command == ":q") {
+			return nil
+		}
+		repl.Line(line)
+	}
+}
+
+// historyReader keeps the lines read for the up arrow
+type historyReader struct {
+	*liner.State
+}
+
+func (h historyReader) Prompt(prompt string) (string, error) {
+	line, err := h.State.Prompt(prompt)
+	if err == nil && strings.TrimSpace(line) != "" {
+		h.AppendHistory(line)
+	}
+	return line, err
+}
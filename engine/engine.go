@@ -0,0 +1,205 @@
+// Package engine lets Go programs load Wabbit modules and call their functions.
+//
+//	e, _ := engine.NewEngine("interp", common.RunOptions{})
+//	m, err := e.Compile(ctx, "mandel.wb", source)
+//	inside, err := m.Lookup("in_mandelbrot").Call(ctx, 0.0, 0.0, int64(1000))
+//
+// Wabbit values are passed as Go values: int as int64 (int is taken too),
+// float as float64, bool as bool and char as rune, the same for the host
+// functions of Register.
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"wabbit-go/check"
+	"wabbit-go/common"
+	"wabbit-go/interpreter"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+	"wabbit-go/wvm"
+)
+
+// Engine compiles modules for one of the in-process backends, interp or wvm
+type Engine struct {
+	backend string
+	options common.RunOptions
+	host    *model.Host
+}
+
+func NewEngine(backend string, options common.RunOptions) (*Engine, error) {
+	switch backend {
+	case "interp", "wvm":
+		return &Engine{backend: backend, options: options, host: model.NewHost()}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", backend)
+}
+
+// Register lets the modules compiled next call fn as the host function of
+// signature, like sqrt(float) float. See model.Host.
+func (e *Engine) Register(signature string, fn model.HostFunc) error {
+	return e.host.Register(signature, fn)
+}
+
+// runtime is a module loaded by a backend, args and results are held in
+// the fields their types say
+type runtime interface {
+	call(ctx context.Context, fn *Function, args []interpreter.Value) (interpreter.Value, error)
+}
+
+// Module is a compiled Wabbit program whose top-level statements ran. Its
+// globals stay between calls, a module isn't safe for concurrent calls.
+type Module struct {
+	Program   *model.Program
+	functions []*Function
+	runtime   runtime
+}
+
+// Param is a parameter of a function
+type Param struct {
+	Name string
+	Type string
+}
+
+// Function is a top-level function of a module
+type Function struct {
+	Name   string
+	Params []Param
+	Result string
+	Decl   *model.FunctionDeclaration
+	module *Module
+}
+
+// Compile parses and checks source, file names it in errors, then runs its
+// top-level statements so the globals its functions use are set. What they
+// print goes to the Stdout of the options. The errors are model.Diagnostics
+// or, when the statements failed, a *model.RuntimeError.
+func (e *Engine) Compile(ctx context.Context, file string, source string) (*Module, error) {
+	program := model.NewProgram(source)
+	program.File, program.Host = file, e.host
+	if err := parser.ParseProgram(program); err != nil {
+		return nil, err
+	}
+	if diagnostics := check.CheckProgram(program); len(diagnostics) > 0 {
+		return nil, model.Diagnostics(diagnostics)
+	}
+	m := &Module{Program: program}
+	for _, statement := range program.Model.(*model.Statements).Statements {
+		if decl, ok := statement.(*model.FunctionDeclaration); ok {
+			m.functions = append(m.functions, newFunction(decl, m))
+		}
+	}
+	var err error
+	switch e.backend {
+	case "interp":
+		c := interpreter.NewContext(ctx, program, e.options)
+		m.runtime = interp{c}
+		_, err = c.Run(program)
+	case "wvm":
+		var machine *wvm.Machine
+		machine, err = wvm.NewMachine(ctx, program, e.options)
+		m.runtime = vm{machine}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CompileFile compiles the module in the file at path
+func (e *Engine) CompileFile(ctx context.Context, path string) (*Module, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return e.Compile(ctx, path, string(source))
+}
+
+func newFunction(decl *model.FunctionDeclaration, m *Module) *Function {
+	fn := &Function{Name: decl.Name.Text, Result: decl.ReturnType.Type(), Decl: decl, module: m}
+	for _, param := range decl.Parameters {
+		fn.Params = append(fn.Params, Param{Name: param.Name.Text, Type: param.Type.Type()})
+	}
+	return fn
+}
+
+// Functions gives the top-level functions in the order they are declared
+func (m *Module) Functions() []*Function {
+	return m.functions
+}
+
+// Lookup gives the top-level function name, nil when there is none
+func (m *Module) Lookup(name string) *Function {
+	for _, fn := range m.functions {
+		if fn.Name == name {
+			return fn
+		}
+	}
+	return nil
+}
+
+// Signature gives fn as it is declared, func add(x int, y int) int
+func (fn *Function) Signature() string {
+	params := make([]string, len(fn.Params))
+	for i, param := range fn.Params {
+		params[i] = param.Name + " " + param.Type
+	}
+	return fmt.Sprintf("func %s(%s) %s", fn.Name, strings.Join(params, ", "), fn.Result)
+}
+
+// Call calls fn with args and gives its result, see the package doc for the
+// Go types of Wabbit values. ctx and the limits of the options of the engine
+// stop it like they stop a run, with a *model.RuntimeError.
+func (fn *Function) Call(ctx context.Context, args ...interface{}) (interface{}, error) {
+	if len(args) != len(fn.Params) {
+		return nil, fmt.Errorf("'%s' takes %d arguments, got %d", fn.Name, len(fn.Params), len(args))
+	}
+	values := make([]interpreter.Value, len(args))
+	for i, arg := range args {
+		value, ok := interpreter.ValueOf(fn.Params[i].Type, arg)
+		if !ok {
+			return nil, fmt.Errorf("argument %d of '%s' must be %s, got %T", i+1, fn.Name, fn.Params[i].Type, arg)
+		}
+		values[i] = value
+	}
+	result, err := fn.module.runtime.call(ctx, fn, values)
+	if err != nil {
+		return nil, err
+	}
+	return result.Go(fn.Result), nil
+}
+
+// interp runs modules on the interpreter
+type interp struct {
+	context *interpreter.Context
+}
+
+func (i interp) call(ctx context.Context, fn *Function, args []interpreter.Value) (interpreter.Value, error) {
+	return i.context.Call(ctx, fn.Name, args)
+}
+
+// vm runs modules on the WVM, which has an int and a float stack
+type vm struct {
+	machine *wvm.Machine
+}
+
+func (v vm) call(ctx context.Context, fn *Function, args []interpreter.Value) (interpreter.Value, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if fn.Params[i].Type == "float" {
+			values[i] = arg.Float
+		} else {
+			values[i] = arg.Int
+		}
+	}
+	result, err := v.machine.Call(ctx, fn.Name, values)
+	if err != nil {
+		return interpreter.Value{}, err
+	}
+	if fn.Result == "float" {
+		return interpreter.Value{Float: result.(float64)}, nil
+	}
+	return interpreter.Value{Int: result.(int)}, nil
+}
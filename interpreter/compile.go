@@ -0,0 +1,363 @@
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"wabbit-go/common"
+	"wabbit-go/model"
+)
+
+// expr and stmt are resolved nodes compiled to Go closures, the switch on the
+// op happens once at compile time instead of on every visit
+type expr func(frame *Frame) Value
+type stmt func(frame *Frame) flow
+
+type compiledFunction struct {
+	slots int
+	body  stmt
+}
+
+// Compiler turns the resolved tree into closures. The closures share the
+// context they are compiled for, one compiled program runs once.
+type Compiler struct {
+	context   *Context
+	functions map[*function]*compiledFunction
+	tail      *compiledFunction // of the tail call, its frame is in the context
+}
+
+// CompileProgram runs program like InterpretProgram does, with closures
+func CompileProgram(program *model.Program, options common.RunOptions) error {
+	return CompileProgramContext(context.Background(), program, options)
+}
+
+// CompileProgramContext runs program like InterpretProgramContext does, with closures
+func CompileProgramContext(ctx context.Context, program *model.Program, options common.RunOptions) (err error) {
+	context := NewContext(ctx, program, options)
+	compiler := &Compiler{context: context, functions: map[*function]*compiledFunction{}}
+	body, globals := Resolve(program)
+	run := compiler.block(body)
+	defer context.recover(&err)
+	run(NewFrame(globals, nil))
+	return nil
+}
+
+func (cc *Compiler) block(nodes []*node) stmt {
+	c := cc.context
+	statements := make([]stmt, len(nodes))
+	for i, n := range nodes {
+		statements[i] = cc.statement(n)
+	}
+	switch len(statements) {
+	case 0:
+		return func(frame *Frame) flow { return flowNormal }
+	case 1:
+		n, s := nodes[0], statements[0]
+		return func(frame *Frame) flow {
+			c.step(n)
+			return s(frame)
+		}
+	}
+	return func(frame *Frame) flow {
+		for i, s := range statements {
+			c.step(nodes[i])
+			if result := s(frame); result != flowNormal {
+				return result
+			}
+		}
+		return flowNormal
+	}
+}
+
+func (cc *Compiler) statement(n *node) stmt {
+	c := cc.context
+	switch n.op {
+	case opPrintInt:
+		x := cc.expression(n.x)
+		return func(frame *Frame) flow {
+			_, err := fmt.Fprintln(c.options.Stdout, x(frame).Int)
+			c.printed(n, err)
+			return flowNormal
+		}
+	case opPrintFloat:
+		x := cc.expression(n.x)
+		return func(frame *Frame) flow {
+			_, err := fmt.Fprintln(c.options.Stdout, x(frame).Float)
+			c.printed(n, err)
+			return flowNormal
+		}
+	case opPrintBool:
+		x := cc.expression(n.x)
+		return func(frame *Frame) flow {
+			_, err := fmt.Fprintln(c.options.Stdout, x(frame).Int != 0)
+			c.printed(n, err)
+			return flowNormal
+		}
+	case opPrintChar:
+		x := cc.expression(n.x)
+		return func(frame *Frame) flow {
+			_, err := fmt.Fprintf(c.options.Stdout, "%c", rune(x(frame).Int))
+			c.printed(n, err)
+			return flowNormal
+		}
+	case opIf:
+		test, body, orelse := cc.expression(n.x), cc.block(n.body), cc.block(n.orelse)
+		return func(frame *Frame) flow {
+			if test(frame).Int != 0 {
+				return body(frame)
+			}
+			return orelse(frame)
+		}
+	case opWhile:
+		test, body := cc.expression(n.x), cc.block(n.body)
+		return func(frame *Frame) flow {
+			for test(frame).Int != 0 {
+				result := body(frame)
+				c.step(n)
+				if result == flowBreak {
+					break
+				}
+				if result == flowReturn || result == flowTailCall {
+					return result
+				}
+			}
+			return flowNormal
+		}
+	case opBreak:
+		return func(frame *Frame) flow { return flowBreak }
+	case opContinue:
+		return func(frame *Frame) flow { return flowContinue }
+	case opReturn:
+		x := cc.expression(n.x)
+		return func(frame *Frame) flow {
+			c.ret = x(frame)
+			return flowReturn
+		}
+	case opTailCall:
+		fn, arguments := cc.function(n.x.fn), cc.arguments(n.x)
+		return func(frame *Frame) flow {
+			cc.tail, c.frame = fn, arguments(frame)
+			c.calls[len(c.calls)-1] = n.x
+			return flowTailCall
+		}
+	case opNop:
+		return func(frame *Frame) flow { return flowNormal }
+	}
+	x := cc.expression(n)
+	return func(frame *Frame) flow {
+		x(frame)
+		return flowNormal
+	}
+}
+
+func (cc *Compiler) load(up int, index int) expr {
+	switch up {
+	case 0:
+		return func(frame *Frame) Value { return frame.slots[index] }
+	case 1:
+		return func(frame *Frame) Value { return frame.parent.slots[index] }
+	}
+	return func(frame *Frame) Value { return frame.outer(up).slots[index] }
+}
+
+func (cc *Compiler) store(up int, index int, x expr) expr {
+	switch up {
+	case 0:
+		return func(frame *Frame) Value {
+			value := x(frame)
+			frame.slots[index] = value
+			return value
+		}
+	case 1:
+		return func(frame *Frame) Value {
+			value := x(frame)
+			frame.parent.slots[index] = value
+			return value
+		}
+	}
+	return func(frame *Frame) Value {
+		value := x(frame)
+		frame.outer(up).slots[index] = value
+		return value
+	}
+}
+
+// constant gives the value of n when it is a constant
+func constant(n *node) (Value, bool) {
+	return n.value, n.op == opConst
+}
+
+// intOp compiles an int operation, with the common case of a constant right
+// operand specialised
+func (cc *Compiler) intOp(n *node, f func(a, b int) Value) expr {
+	x := cc.expression(n.x)
+	if k, ok := constant(n.y); ok {
+		return func(frame *Frame) Value { return f(x(frame).Int, k.Int) }
+	}
+	y := cc.expression(n.y)
+	return func(frame *Frame) Value { return f(x(frame).Int, y(frame).Int) }
+}
+
+func (cc *Compiler) floatOp(n *node, f func(a, b float64) Value) expr {
+	x := cc.expression(n.x)
+	if k, ok := constant(n.y); ok {
+		return func(frame *Frame) Value { return f(x(frame).Float, k.Float) }
+	}
+	y := cc.expression(n.y)
+	return func(frame *Frame) Value { return f(x(frame).Float, y(frame).Float) }
+}
+
+func (cc *Compiler) expression(n *node) expr {
+	c := cc.context
+	switch n.op {
+	case opConst:
+		value := n.value
+		return func(frame *Frame) Value { return value }
+	case opLoad:
+		return cc.load(n.up, n.index)
+	case opStore:
+		return cc.store(n.up, n.index, cc.expression(n.x))
+	case opExpr:
+		return cc.expression(n.x)
+
+	case opAddInt:
+		if n.x.op == opLoad && n.x.up == 0 {
+			// i + k, the most common operation in loops
+			if k, ok := constant(n.y); ok {
+				index := n.x.index
+				return func(frame *Frame) Value { return Value{Int: frame.slots[index].Int + k.Int} }
+			}
+		}
+		return cc.intOp(n, func(a, b int) Value { return Value{Int: a + b} })
+	case opAddFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return Value{Float: a + b} })
+	case opSubInt:
+		return cc.intOp(n, func(a, b int) Value { return Value{Int: a - b} })
+	case opSubFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return Value{Float: a - b} })
+	case opMulInt:
+		return cc.intOp(n, func(a, b int) Value { return Value{Int: a * b} })
+	case opMulFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return Value{Float: a * b} })
+	case opDivInt:
+		return cc.intOp(n, func(a, b int) Value { return c.divide(n, a, b) })
+	case opDivFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return Value{Float: a / b} })
+	case opNegInt:
+		x := cc.expression(n.x)
+		return func(frame *Frame) Value { return Value{Int: -x(frame).Int} }
+	case opNegFloat:
+		x := cc.expression(n.x)
+		return func(frame *Frame) Value { return Value{Float: -x(frame).Float} }
+	case opNot:
+		x := cc.expression(n.x)
+		return func(frame *Frame) Value { return Value{Int: 1 - x(frame).Int} }
+
+	case opLtInt:
+		return cc.intOp(n, func(a, b int) Value { return boolValue(a < b) })
+	case opLtFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return boolValue(a < b) })
+	case opLeInt:
+		return cc.intOp(n, func(a, b int) Value { return boolValue(a <= b) })
+	case opLeFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return boolValue(a <= b) })
+	case opGtInt:
+		return cc.intOp(n, func(a, b int) Value { return boolValue(a > b) })
+	case opGtFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return boolValue(a > b) })
+	case opGeInt:
+		return cc.intOp(n, func(a, b int) Value { return boolValue(a >= b) })
+	case opGeFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return boolValue(a >= b) })
+	case opEqInt:
+		return cc.intOp(n, func(a, b int) Value { return boolValue(a == b) })
+	case opEqFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return boolValue(a == b) })
+	case opNeInt:
+		return cc.intOp(n, func(a, b int) Value { return boolValue(a != b) })
+	case opNeFloat:
+		return cc.floatOp(n, func(a, b float64) Value { return boolValue(a != b) })
+	case opAnd:
+		x, y := cc.expression(n.x), cc.expression(n.y)
+		return func(frame *Frame) Value {
+			if x(frame).Int == 0 {
+				return Value{}
+			}
+			return y(frame)
+		}
+	case opOr:
+		x, y := cc.expression(n.x), cc.expression(n.y)
+		return func(frame *Frame) Value {
+			if x(frame).Int != 0 {
+				return Value{Int: 1}
+			}
+			return y(frame)
+		}
+
+	case opIntToFloat:
+		x := cc.expression(n.x)
+		return func(frame *Frame) Value { return Value{Float: float64(x(frame).Int)} }
+	case opFloatToInt:
+		x := cc.expression(n.x)
+		return func(frame *Frame) Value { return Value{Int: int(x(frame).Float)} }
+
+	case opCall:
+		fn, arguments := cc.function(n.fn), cc.arguments(n)
+		return func(frame *Frame) Value {
+			callee := arguments(frame)
+			c.enter(n)
+			c.ret = Value{}
+			for fn := fn; fn.body(callee) == flowTailCall; {
+				fn, callee = cc.tail, c.frame
+				c.ret = Value{}
+			}
+			c.leave()
+			return c.ret
+		}
+	case opHostCall:
+		args := make([]expr, len(n.args))
+		for i, arg := range n.args {
+			args[i] = cc.expression(arg)
+		}
+		return func(frame *Frame) Value {
+			values := make([]Value, len(args))
+			for i, arg := range args {
+				values[i] = arg(frame)
+			}
+			return c.hostCall(n, values)
+		}
+	case opCompound:
+		body, x := cc.block(n.body), cc.expression(n.x)
+		return func(frame *Frame) Value {
+			body(frame)
+			return x(frame)
+		}
+	}
+	panic(fmt.Sprintf("Can't compile %#v", n.source))
+}
+
+// arguments compiles making the frame of the call n
+func (cc *Compiler) arguments(n *node) func(frame *Frame) *Frame {
+	fn, up := cc.function(n.fn), n.up
+	args := make([]expr, len(n.args))
+	for i, arg := range n.args {
+		args[i] = cc.expression(arg)
+	}
+	return func(frame *Frame) *Frame {
+		callee := NewFrame(fn.slots, frame.outer(up))
+		for i, arg := range args {
+			callee.slots[i] = arg(frame)
+		}
+		return callee
+	}
+}
+
+// function compiles fn once, calls inside its own body see it unfinished
+func (cc *Compiler) function(fn *function) *compiledFunction {
+	if compiled, ok := cc.functions[fn]; ok {
+		return compiled
+	}
+	compiled := &compiledFunction{slots: fn.slots}
+	cc.functions[fn] = compiled
+	compiled.body = cc.block(fn.body)
+	return compiled
+}
@@ -0,0 +1,81 @@
+package interpreter
+
+import (
+	"wabbit-go/model"
+)
+
+// Debugger is told about every statement before the tree interpreter runs
+// it, an error stops the program there. While it is told, the Context of
+// the program shows its stack and variables.
+type Debugger interface {
+	Statement(c *Context, loc model.Locator) error
+}
+
+// Variable is a variable in scope at the statement a debugger is told about
+type Variable struct {
+	Name  string
+	Type  string
+	Value Value
+}
+
+// SetDebugger makes d the debugger of the statements run next
+func (c *Context) SetDebugger(d Debugger) {
+	c.debugger = d
+}
+
+func (c *Context) debug(n *node, frame *Frame) {
+	c.current, c.locals = n, frame
+	err := c.debugger.Statement(c, c.program.Location(n.source))
+	c.current, c.locals = nil, nil
+	if err != nil {
+		c.stop(n.source, err)
+	}
+}
+
+// Depth gives how many calls are running
+func (c *Context) Depth() int {
+	return len(c.calls)
+}
+
+// Stack gives the calls running, innermost first, like the stack of a
+// runtime error stopped at the current statement
+func (c *Context) Stack() []model.StackFrame {
+	if c.current == nil {
+		return nil
+	}
+	return c.runtimeError(c.program.Location(c.current.source), "").Stack
+}
+
+// Variables gives the variables in scope at the current statement, the
+// innermost first. The ones they shadow aren't given.
+func (c *Context) Variables() []Variable {
+	if c.current == nil {
+		return nil
+	}
+	// the frames up from the current one to the globals
+	depth := 0
+	for frame := c.locals; frame.parent != nil; frame = frame.parent {
+		depth++
+	}
+	var vars []Variable
+	seen := map[string]bool{}
+	for v := c.current.vars; v != nil; v = v.next {
+		if seen[v.name] {
+			continue
+		}
+		seen[v.name] = true
+		value := c.locals.outer(depth - v.depth).slots[v.index]
+		vars = append(vars, Variable{Name: v.name, Type: v.typ, Value: value})
+	}
+	return vars
+}
+
+// Lookup gives the variable name in scope at the current statement
+func (c *Context) Lookup(name string) (Variable, bool) {
+	for _, v := range c.Variables() {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variable{}, false
+}
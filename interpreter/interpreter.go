@@ -1,461 +1,394 @@
 package interpreter
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"strconv"
+	"runtime"
 	"wabbit-go/common"
 	"wabbit-go/model"
 )
 
-type Context struct {
-	env   *common.ChainMap
-	level int
+// flow is how a statement finished
+type flow int
+
+const (
+	flowNormal flow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+	flowTailCall // the frame to call next is in the context
+)
+
+// Frame holds the slots of the globals or of one function call
+type Frame struct {
+	slots  []Value
+	parent *Frame // frame of the function's definition
 }
 
-type WabbitValue struct {
-	Type  string
-	Value interface{}
+func NewFrame(slots int, parent *Frame) *Frame {
+	return &Frame{slots: make([]Value, slots), parent: parent}
 }
 
-type FunctionClosure struct {
-	Node    *model.FunctionDeclaration
-	Context *Context
+// outer gives the frame up levels out of f
+func (f *Frame) outer(up int) *Frame {
+	for ; up > 0; up-- {
+		f = f.parent
+	}
+	return f
 }
 
-func (w *WabbitValue) String() string {
-	return fmt.Sprintf("%v", w.Value)
+type Context struct {
+	program *model.Program
+	options *common.RunOptions
+	limiter *common.Limiter
+	names   *Resolver // of the globals, kept between runs
+	globals *Frame
+	ret     Value   // value of the last return statement
+	calls   []*node // running, innermost last
+	frame   *Frame  // of the tail call
+
+	debugger Debugger
+	current  *node  // statement the debugger is told about
+	locals   *Frame // its frame
 }
 
-type WabbitVar struct {
-	Kind  string
-	Value *WabbitValue
+// InterpretProgram runs program, what it prints goes to options.Stdout.
+// A program stopped by a runtime error gives a *model.RuntimeError.
+func InterpretProgram(program *model.Program, options common.RunOptions) error {
+	return InterpretProgramContext(context.Background(), program, options)
 }
 
-func NewContext(env *common.ChainMap, level int) *Context {
-	if env == nil {
-		env = common.NewChainMap()
+// InterpretProgramContext runs program until it ends, fails, reaches a limit
+// of options or ctx is done. The error of a limit or of ctx is wrapped in
+// the *model.RuntimeError telling where the program stopped.
+func InterpretProgramContext(ctx context.Context, program *model.Program, options common.RunOptions) error {
+	_, err := NewContext(ctx, program, options).Run(program)
+	return err
+}
+
+func NewContext(ctx context.Context, program *model.Program, options common.RunOptions) *Context {
+	context := &Context{program: program, options: options.WithDefaults()}
+	context.limiter = common.NewLimiter(ctx, context.options)
+	context.names = NewResolver(program)
+	context.globals = NewFrame(0, nil)
+	return context
+}
+
+// Run runs a checked program after the ones run before with c, their
+// globals and functions are still there. The result is the value of the
+// last statement when it is an expression.
+func (c *Context) Run(program *model.Program) (result Value, err error) {
+	c.program, c.names.program = program, program
+	body := resolveStatements(program.Model.(*model.Statements), c.names)
+	for len(c.globals.slots) < *c.names.slots {
+		c.globals.slots = append(c.globals.slots, Value{})
+	}
+	c.calls = c.calls[:0]
+	defer c.recover(&err)
+	if n := len(body); n > 0 && body[n-1].op == opExpr {
+		c.exec(body[:n-1], c.globals)
+		c.before(body[n-1], c.globals)
+		return c.eval(body[n-1], c.globals), nil
 	}
-	return &Context{env: env, level: level}
+	c.exec(body, c.globals)
+	return Value{}, nil
 }
 
-func (c *Context) Define(name string, value *WabbitValue) error {
-	if _, ok := c.env.GetValue(name); ok {
-		return errors.New(fmt.Sprintf("%s already defined", name))
+// Call calls the global function name with args after Run, ctx and the
+// limits of the options stop it like they stop a run. Args and the result
+// are held in the fields their checked types say.
+func (c *Context) Call(ctx context.Context, name string, args []Value) (result Value, err error) {
+	b, ok := c.names.env.GetValue(name)
+	if !ok || b.(*binding).kind != "func" {
+		return Value{}, fmt.Errorf("undefined function '%s'", name)
 	}
-	c.env.SetValue(name, value)
-	return nil
+	fn := b.(*binding).fn
+	if len(args) != fn.params {
+		return Value{}, fmt.Errorf("'%s' takes %d arguments, got %d", name, fn.params, len(args))
+	}
+	c.limiter = common.NewLimiter(ctx, c.options)
+	c.calls = c.calls[:0]
+	defer c.recover(&err)
+	callee := NewFrame(fn.slots, c.globals)
+	copy(callee.slots, args)
+	// the stack of an error ends at the declaration of the function called
+	return c.invoke(&node{op: opCall, source: fn.source, fn: fn}, callee), nil
 }
 
-func (c *Context) Assign(name string, val *WabbitValue) error {
-	value, _ := c.Lookup(name)
-	_ = value.Value.(*WabbitVar).Store(val) // if value store val it will return error
-	return nil
+// recover turns the panic of a runtime error into the error of the run,
+// a Go runtime panic becomes one stopping the innermost call
+func (c *Context) recover(err *error) {
+	r := recover()
+	switch e := r.(type) {
+	case nil:
+	case *model.RuntimeError:
+		*err = e
+	case runtime.Error:
+		var loc model.Locator
+		if len(c.calls) > 0 {
+			loc = c.program.Location(c.calls[len(c.calls)-1].source)
+		}
+		*err = c.runtimeError(loc, e.Error())
+	default:
+		panic(r)
+	}
 }
 
-func (c *Context) Lookup(name string) (*WabbitValue, bool) {
-	value, ok := c.env.GetValue(name)
-	if !ok {
-		return nil, ok
+// runtimeError gives the error stopping the program at loc with the stack of the calls running
+func (c *Context) runtimeError(loc model.Locator, message string) *model.RuntimeError {
+	e := &model.RuntimeError{Message: message, Loc: loc}
+	for i := len(c.calls) - 1; i >= 0; i-- {
+		e.Stack = append(e.Stack, model.StackFrame{Function: c.calls[i].fn.name, Loc: loc})
+		loc = c.program.Location(c.calls[i].source)
 	}
-	return value.(*WabbitValue), ok
+	e.Stack = append(e.Stack, model.StackFrame{Function: model.TopLevel, Loc: loc})
+	return e
 }
 
-func (c *Context) NewBlock() *Context {
-	return NewContext(c.env.NewChild(), c.level+1)
+func (c *Context) fail(source model.Node, message string) {
+	panic(c.runtimeError(c.program.Location(source), message))
 }
 
-func (w *WabbitVar) Load() *WabbitValue {
-	return w.Value
+// stop ends the program at source for err
+func (c *Context) stop(source model.Node, err error) {
+	e := c.runtimeError(c.program.Location(source), err.Error())
+	e.Err = err
+	panic(e)
 }
 
-func (w *WabbitVar) Store(value *WabbitValue) error {
-	if w.Kind == "const" {
-		return errors.New("Can't store const")
+// step counts a step of the program at n
+func (c *Context) step(n *node) {
+	if err := c.limiter.Step(); err != nil {
+		c.stop(n.source, err)
 	}
-	if value.Type != w.Value.Type {
-		return errors.New("Type error in assignment")
+}
+
+// before counts the step of the statement n and tells the debugger
+func (c *Context) before(n *node, frame *Frame) {
+	c.step(n)
+	if c.debugger != nil && n.op != opNop {
+		c.debug(n, frame)
 	}
-	w.Value = value
-	return nil
 }
 
-func InterpretProgram(program *model.Program) interface{} {
-	context := NewContext(nil, 0)
+// printed stops the program at n when what it printed couldn't be written
+func (c *Context) printed(n *node, err error) {
+	if err != nil {
+		c.stop(n.source, err)
+	}
+}
 
-	_ = context.Define("int", &WabbitValue{Type: "cast", Value: "int"})
-	_ = context.Define("float", &WabbitValue{Type: "cast", Value: "float"})
-	_ = context.Define("char", &WabbitValue{Type: "cast", Value: "char"})
-	_ = context.Define("bool", &WabbitValue{Type: "cast", Value: "bool"})
+// enter pushes call on the stack, leave pops it when it returns
+func (c *Context) enter(call *node) {
+	if len(c.calls) >= c.options.MaxDepth {
+		c.stop(call.source, common.ErrDepthLimit)
+	}
+	c.calls = append(c.calls, call)
+}
 
-	// 解释输入的程序模型，并返回解释结果
-	return InterpretNode(program.Model, context)
+func (c *Context) leave() {
+	c.calls = c.calls[:len(c.calls)-1]
 }
 
-func InterpretNode(node model.Node, context *Context) *WabbitValue {
-	switch v := node.(type) {
-	case *model.Integer:
-		return &WabbitValue{"int", v.Value}
-	case *model.Float:
-		return &WabbitValue{"float", v.Value}
-	case *model.Character:
-		unquoted, err := strconv.Unquote(v.Value)
-		if err != nil {
-			panic(err)
-		}
-		return &WabbitValue{"char", rune(unquoted[0])}
-	case *model.Name:
-		value, _ := context.Lookup(v.Text) // somethings we may need exist
-		value_var, ok := value.Value.(*WabbitVar)
-		if ok {
-			return value_var.Load()
-		} else {
-			return value
-		}
-	case *model.NameType:
-		return &WabbitValue{Type: "type", Value: v.Name}
-	case *model.NameBool:
-		return &WabbitValue{Type: "bool", Value: v.Name == "true"}
-	case *model.IntegerType:
-		return &WabbitValue{Type: "type", Value: "int"}
-	case *model.FloatType:
-		return &WabbitValue{Type: "type", Value: "float"}
-
-	case *model.Add:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left.Type == "int" && right.Type == "int" {
-			return &WabbitValue{"int", left.Value.(int) + right.Value.(int)}
-		} else if left.Type == "float" && right.Type == "float" {
-			return &WabbitValue{"float", left.Value.(float64) + right.Value.(float64)}
-		} else {
-			// we think it's a type error
-			return &WabbitValue{"error", "type error"}
-		}
-	case *model.Mul:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left.Type == "int" && right.Type == "int" {
-			return &WabbitValue{"int", left.Value.(int) * right.Value.(int)}
-		} else if left.Type == "float" && right.Type == "float" {
-			return &WabbitValue{"float", left.Value.(float64) * right.Value.(float64)}
-		} else {
-			// we think it's a type error
-			return &WabbitValue{"error", "type error"}
-		}
-	case *model.Sub:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left.Type == "int" && right.Type == "int" {
-			return &WabbitValue{"int", left.Value.(int) - right.Value.(int)}
-		} else if left.Type == "float" && right.Type == "float" {
-			return &WabbitValue{"float", left.Value.(float64) - right.Value.(float64)}
-		} else {
-			// we think it's a type error
-			return &WabbitValue{"error", "type error"}
-		}
-	case *model.Div:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left.Type == "int" && right.Type == "int" {
-			return &WabbitValue{"int", left.Value.(int) / right.Value.(int)}
-		} else if left.Type == "float" && right.Type == "float" {
-			return &WabbitValue{"float", left.Value.(float64) / right.Value.(float64)}
-		} else {
-			// we think it's a type error
-			return &WabbitValue{"error", "type error"}
-		}
+// arguments gives the frame of the call n made from frame
+func (c *Context) arguments(n *node, frame *Frame) *Frame {
+	callee := NewFrame(n.fn.slots, frame.outer(n.up))
+	for i, arg := range n.args {
+		callee.slots[i] = c.eval(arg, frame)
+	}
+	return callee
+}
 
-	case *model.Neg:
-		right := InterpretNode(v.Operand, context)
-		if right.Type == "int" {
-			return &WabbitValue{"int", -right.Value.(int)}
-		} else if right.Type == "float" {
-			return &WabbitValue{"float", -right.Value.(float64)}
-		} else {
-			// we think it's a type error
-			return &WabbitValue{"error", "type error"}
-		}
-	case *model.Pos:
-		right := InterpretNode(v.Operand, context)
-		if right.Type == "int" {
-			return &WabbitValue{"int", +right.Value.(int)}
-		} else if right.Type == "float" {
-			return &WabbitValue{"float", +right.Value.(float64)}
-		} else {
-			// we think it's a type error
-			return &WabbitValue{"error", "type error"}
-		}
-	case *model.Not:
-		right := InterpretNode(v.Operand, context)
-		if right.Type == "bool" {
-			return &WabbitValue{"int", !right.Value.(bool)}
-		} else {
-			// we think it's a type error
-			return &WabbitValue{"error", "type error"}
-		}
-	case *model.VarDeclaration:
-		var val *WabbitValue
-		if v.Value != nil {
-			val = InterpretNode(v.Value, context)
-		} else {
-			val = &WabbitValue{Type: v.Type.Type(), Value: nil}
-		}
-		context.Define(v.Name.Text, &WabbitValue{Type: val.Type, Value: &WabbitVar{"var", val}})
-	case *model.ConstDeclaration:
-		var val *WabbitValue
-		if v.Value != nil {
-			val = InterpretNode(v.Value, context)
-		} else {
-			val = &WabbitValue{Type: v.Type.Type(), Value: nil}
-		}
-		context.Define(v.Name.Text, &WabbitValue{Type: val.Type, Value: &WabbitVar{"constant", val}})
-
-	case *model.Lt:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-		if left.Type == "int" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
-		} else if left.Type == "float" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(float64) < right.Value.(float64)}
-		} else if left.Type == "char" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(rune) < right.Value.(rune)}
-		} else {
-			return &WabbitValue{Type: "error", Value: "type error"}
-		}
-	case *model.Le:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-		if left.Type == "int" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(int) <= right.Value.(int)}
-		} else if left.Type == "float" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(float64) <= right.Value.(float64)}
-		} else if left.Type == "char" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(rune) <= right.Value.(rune)}
-		} else {
-			return &WabbitValue{Type: "error", Value: "type error"}
-		}
-	case *model.Gt:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-		if left.Type == "int" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(int) > right.Value.(int)}
-		} else if left.Type == "float" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(float64) > right.Value.(float64)}
-		} else if left.Type == "char" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(rune) > right.Value.(rune)}
-		} else {
-			return &WabbitValue{Type: "error", Value: "type error"}
-		}
-	case *model.Ge:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-		if left.Type == "int" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(int) >= right.Value.(int)}
-		} else if left.Type == "float" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(float64) >= right.Value.(float64)}
-		} else if left.Type == "char" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(rune) >= right.Value.(rune)}
-		} else {
-			return &WabbitValue{Type: "error", Value: "type error"}
-		}
-	case *model.Eq:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-		if left.Type == "int" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(int) == right.Value.(int)}
-		} else if left.Type == "float" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(float64) == right.Value.(float64)}
-		} else if left.Type == "bool" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(bool) == right.Value.(bool)}
-		} else if left.Type == "char" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(rune) == right.Value.(rune)}
-		} else {
-			return &WabbitValue{Type: "error", Value: "type error"}
-		}
-	case *model.Ne:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-		if left.Type == "int" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(int) != right.Value.(int)}
-		} else if left.Type == "float" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(float64) != right.Value.(float64)}
-		} else if left.Type == "bool" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(bool) != right.Value.(bool)}
-		} else if left.Type == "char" {
-			return &WabbitValue{Type: "bool", Value: left.Value.(rune) != right.Value.(rune)}
-		} else {
-			return &WabbitValue{Type: "error", Value: "type error"}
-		}
-	case *model.LogOr:
-		left := InterpretNode(v.Left, context)
-		if left.Value.(bool) {
-			return &WabbitValue{Type: "bool", Value: true}
-		}
-		right := InterpretNode(v.Right, context)
-		return &WabbitValue{Type: "bool", Value: left.Value.(bool) || right.Value.(bool)}
-	case *model.LogAnd:
-		left := InterpretNode(v.Left, context)
-		if !left.Value.(bool) {
-			return &WabbitValue{Type: "bool", Value: false}
-		}
-		right := InterpretNode(v.Right, context)
-		return &WabbitValue{Type: "bool", Value: left.Value.(bool) && right.Value.(bool)}
-	case *model.Assignment:
-		val := InterpretNode(v.Value, context)
-		// assign the value to the name
-		_ = context.Assign(v.Location.(*model.Name).Text, val)
-		return val
-	case *model.PrintStatement:
-		value := InterpretNode(v.Value, context)
-		switch value.Type {
-		case "char":
-			fmt.Printf("%c", value.Value.(rune)) // we may need change
-		case "bool":
-			if value.Value.(bool) {
-				fmt.Println("true")
-			} else {
-				fmt.Println("false")
-			}
-		default:
-			fmt.Println(value)
-		}
-	case *model.Statements:
-
-		var result *WabbitValue
-		for _, statement := range v.Statements {
-			result = InterpretNode(statement, context)
-			// need check break return too
-			if result != nil {
-				if result.Type == "break" || result.Type == "return" || result.Type == "continue" {
-					return result
-				}
-			}
-		}
-		return result
-
-	case *model.ExpressionAsStatement:
-		return InterpretNode(v.Expression, context)
-		// should return a = {a , b , c} must return one
-		// when c as ExpressionAsStatement
-
-	case *model.Grouping:
-		return InterpretNode(v.Expression, context)
-
-	case *model.IfStatement:
-		condition := InterpretNode(v.Test, context)
-		if condition.Value.(bool) {
-			return InterpretNode(&v.Consequence, context)
-		} else if v.Alternative != nil {
-			return InterpretNode(v.Alternative, context)
-		}
+// call runs the function of n
+func (c *Context) call(n *node, frame *Frame) Value {
+	return c.invoke(n, c.arguments(n, frame))
+}
 
-	case *model.BreakStatement:
-		return &WabbitValue{"break", nil}
-	case *model.ContinueStatement:
-		return &WabbitValue{"continue", nil}
-	case *model.ReturnStatement:
-		value := InterpretNode(v.Value, context)
-		return &WabbitValue{"return", value}
-
-	case *model.WhileStatement:
-		for true {
-			condtion := InterpretNode(v.Test, context)
-			if condtion.Type == "error" { // fix it
-				return condtion
-			}
-			if condtion.Value.(bool) {
-				result := InterpretNode(&v.Body, context)
-				//fmt.Println("result %v", result)
-				if result == nil {
-					continue
-				}
-				if result.Type == "break" {
-					break
-				} else if result.Type == "continue" {
-					continue
-				} else if result.Type == "return" {
-					return result
-				}
-				continue
+// invoke runs the function of n in its frame callee. Tail calls in its body
+// come back here and run in the loop instead of nesting, in place of n on
+// the stack.
+func (c *Context) invoke(n *node, callee *Frame) Value {
+	c.enter(n)
+	c.ret = Value{}
+	for c.exec(c.calls[len(c.calls)-1].fn.body, callee) == flowTailCall {
+		callee = c.frame
+		c.ret = Value{}
+	}
+	c.leave()
+	return c.ret
+}
+
+// hostCall calls the host function of n with the values of its arguments
+func (c *Context) hostCall(n *node, args []Value) Value {
+	f := n.host
+	goArgs := make([]interface{}, len(args))
+	for i, arg := range args {
+		goArgs[i] = arg.Go(f.Params[i])
+	}
+	result, err := f.Func(goArgs)
+	if err != nil {
+		c.stop(n.source, err)
+	}
+	value, ok := ValueOf(f.Result, result)
+	if !ok {
+		c.fail(n.source, fmt.Sprintf("host function '%s' returned %T, want %s", f.Name, result, f.Result))
+	}
+	return value
+}
+
+// divide is x / y of ints, n is the division
+func (c *Context) divide(n *node, x int, y int) Value {
+	if y == 0 {
+		c.fail(n.source, "division by zero")
+	}
+	return Value{Int: x / y}
+}
+
+func (c *Context) exec(statements []*node, frame *Frame) flow {
+	for _, n := range statements {
+		c.before(n, frame)
+		switch n.op {
+		case opPrintInt:
+			_, err := fmt.Fprintln(c.options.Stdout, c.eval(n.x, frame).Int)
+			c.printed(n, err)
+		case opPrintFloat:
+			_, err := fmt.Fprintln(c.options.Stdout, c.eval(n.x, frame).Float)
+			c.printed(n, err)
+		case opPrintBool:
+			_, err := fmt.Fprintln(c.options.Stdout, c.eval(n.x, frame).Int != 0)
+			c.printed(n, err)
+		case opPrintChar:
+			_, err := fmt.Fprintf(c.options.Stdout, "%c", rune(c.eval(n.x, frame).Int))
+			c.printed(n, err)
+		case opIf:
+			var result flow
+			if c.eval(n.x, frame).Int != 0 {
+				result = c.exec(n.body, frame)
 			} else {
-				break
+				result = c.exec(n.orelse, frame)
 			}
-		}
-	case *model.FunctionDeclaration:
-		// we should check the function name is not defined
-		context.Define(v.Name.Text, &WabbitValue{"func", &FunctionClosure{v, context}})
-
-	case *model.FunctionApplication:
-		value := InterpretNode(v.Func, context) // while lookup
-
-		//savedContext = funtionClosure.Value.Context
-		// TODO make it as builtin function...
-		if value.Type == "cast" { // this is conversion function
-			if value.Value == "int" {
-				result := InterpretNode(v.Arguments[0], context) // # type conversion must only have one args ?
-				if result.Type == "float" {
-					return &WabbitValue{"int", int(result.Value.(float64))}
-				} else if result.Type == "char" {
-					return &WabbitValue{"int", int(result.Value.(rune))}
-				}
+			if result != flowNormal {
 				return result
 			}
-			if value.Value == "float" {
-				result := InterpretNode(v.Arguments[0], context) // # type conversion must only have one args ?
-				if result.Type == "int" {
-					return &WabbitValue{"float", float64(result.Value.(int))}
-				} else if result.Type == "char" {
-					return &WabbitValue{"float", float64(result.Value.(rune))}
+		case opWhile:
+			for c.eval(n.x, frame).Int != 0 {
+				result := c.exec(n.body, frame)
+				// an empty body still takes a step
+				c.step(n)
+				if result == flowBreak {
+					break
 				}
-				return result
-			}
-			if value.Value == "char" {
-				result := InterpretNode(v.Arguments[0], context) // # type conversion must only have one args ?
-				if result.Type == "int" {
-					return &WabbitValue{"char", rune(result.Value.(int))}
-				} else if result.Type == "float64" {
-					return &WabbitValue{"float", rune(result.Value.(float64))}
+				if result == flowReturn || result == flowTailCall {
+					return result
 				}
-				return result
 			}
+		case opBreak:
+			return flowBreak
+		case opContinue:
+			return flowContinue
+		case opReturn:
+			c.ret = c.eval(n.x, frame)
+			return flowReturn
+		case opTailCall:
+			c.frame = c.arguments(n.x, frame)
+			c.calls[len(c.calls)-1] = n.x
+			return flowTailCall
+		case opNop:
+		default:
+			c.eval(n, frame)
 		}
-		// custom function and it should be....
-		if value.Type == "func" {
-			funtionClosure := value.Value.(*FunctionClosure)
-			eval_context := funtionClosure.Context.NewBlock()
-			for i, arg := range v.Arguments {
-				// TODO check the type
-				//fmt.Println("arg %v", arg)
-				argValue := InterpretNode(arg, context) // arg eval in current context
-				eval_context.Define(
-					funtionClosure.Node.Parameters[i].Name.Text,
-					&WabbitValue{
-						funtionClosure.Node.Parameters[i].Type.Type(),
-						&WabbitVar{"var", argValue}})
-			}
+	}
+	return flowNormal
+}
 
-			bodyValue := InterpretNode(&funtionClosure.Node.Body, eval_context)
-			if bodyValue != nil {
-				if bodyValue.Type == "return" {
-					return bodyValue.Value.(*WabbitValue)
-				}
-				return bodyValue
-			}
+func (c *Context) eval(n *node, frame *Frame) Value {
+	switch n.op {
+	case opConst:
+		return n.value
+	case opLoad:
+		return frame.outer(n.up).slots[n.index]
+	case opStore:
+		value := c.eval(n.x, frame)
+		frame.outer(n.up).slots[n.index] = value
+		return value
+	case opExpr:
+		return c.eval(n.x, frame)
+
+	case opAddInt:
+		return Value{Int: c.eval(n.x, frame).Int + c.eval(n.y, frame).Int}
+	case opAddFloat:
+		return Value{Float: c.eval(n.x, frame).Float + c.eval(n.y, frame).Float}
+	case opSubInt:
+		return Value{Int: c.eval(n.x, frame).Int - c.eval(n.y, frame).Int}
+	case opSubFloat:
+		return Value{Float: c.eval(n.x, frame).Float - c.eval(n.y, frame).Float}
+	case opMulInt:
+		return Value{Int: c.eval(n.x, frame).Int * c.eval(n.y, frame).Int}
+	case opMulFloat:
+		return Value{Float: c.eval(n.x, frame).Float * c.eval(n.y, frame).Float}
+	case opDivInt:
+		return c.divide(n, c.eval(n.x, frame).Int, c.eval(n.y, frame).Int)
+	case opDivFloat:
+		return Value{Float: c.eval(n.x, frame).Float / c.eval(n.y, frame).Float}
+	case opNegInt:
+		return Value{Int: -c.eval(n.x, frame).Int}
+	case opNegFloat:
+		return Value{Float: -c.eval(n.x, frame).Float}
+	case opNot:
+		return Value{Int: 1 - c.eval(n.x, frame).Int}
+
+	case opLtInt:
+		return boolValue(c.eval(n.x, frame).Int < c.eval(n.y, frame).Int)
+	case opLtFloat:
+		return boolValue(c.eval(n.x, frame).Float < c.eval(n.y, frame).Float)
+	case opLeInt:
+		return boolValue(c.eval(n.x, frame).Int <= c.eval(n.y, frame).Int)
+	case opLeFloat:
+		return boolValue(c.eval(n.x, frame).Float <= c.eval(n.y, frame).Float)
+	case opGtInt:
+		return boolValue(c.eval(n.x, frame).Int > c.eval(n.y, frame).Int)
+	case opGtFloat:
+		return boolValue(c.eval(n.x, frame).Float > c.eval(n.y, frame).Float)
+	case opGeInt:
+		return boolValue(c.eval(n.x, frame).Int >= c.eval(n.y, frame).Int)
+	case opGeFloat:
+		return boolValue(c.eval(n.x, frame).Float >= c.eval(n.y, frame).Float)
+	case opEqInt:
+		return boolValue(c.eval(n.x, frame).Int == c.eval(n.y, frame).Int)
+	case opEqFloat:
+		return boolValue(c.eval(n.x, frame).Float == c.eval(n.y, frame).Float)
+	case opNeInt:
+		return boolValue(c.eval(n.x, frame).Int != c.eval(n.y, frame).Int)
+	case opNeFloat:
+		return boolValue(c.eval(n.x, frame).Float != c.eval(n.y, frame).Float)
+	case opAnd:
+		if c.eval(n.x, frame).Int == 0 {
+			return Value{}
 		}
-	case *model.CompoundExpression:
-		return InterpretNode(&v.Statements, context)
-	default:
-		panic(fmt.Sprintf("Can't intepre %#v to source", v))
+		return c.eval(n.y, frame)
+	case opOr:
+		if c.eval(n.x, frame).Int != 0 {
+			return Value{Int: 1}
+		}
+		return c.eval(n.y, frame)
+
+	case opIntToFloat:
+		return Value{Float: float64(c.eval(n.x, frame).Int)}
+	case opFloatToInt:
+		return Value{Int: int(c.eval(n.x, frame).Float)}
+
+	case opCall:
+		return c.call(n, frame)
+	case opHostCall:
+		args := make([]Value, len(n.args))
+		for i, arg := range n.args {
+			args[i] = c.eval(arg, frame)
+		}
+		return c.hostCall(n, args)
+	case opCompound:
+		// the checker keeps break, continue and return from leaving it
+		c.exec(n.body, frame)
+		return c.eval(n.x, frame)
 	}
-
-	return nil
+	panic(fmt.Sprintf("Can't interpret %#v", n.source))
 }
@@ -0,0 +1,405 @@
+package interpreter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"wabbit-go/common"
+	"wabbit-go/model"
+)
+
+// op is what a resolved node does, picked for the checked types of its operands
+type op int
+
+const (
+	opConst op = iota
+	opLoad
+	opStore
+	opAddInt
+	opAddFloat
+	opSubInt
+	opSubFloat
+	opMulInt
+	opMulFloat
+	opDivInt
+	opDivFloat
+	opNegInt
+	opNegFloat
+	opNot
+	opLtInt
+	opLtFloat
+	opLeInt
+	opLeFloat
+	opGtInt
+	opGtFloat
+	opGeInt
+	opGeFloat
+	opEqInt
+	opEqFloat
+	opNeInt
+	opNeFloat
+	opAnd
+	opOr
+	opIntToFloat
+	opFloatToInt
+	opCall
+	opHostCall
+	opCompound
+
+	// statements
+	opPrintInt
+	opPrintFloat
+	opPrintBool
+	opPrintChar
+	opExpr
+	opIf
+	opWhile
+	opBreak
+	opContinue
+	opReturn
+	opTailCall // return f(...), x is the call
+	opNop
+)
+
+// Value is an unboxed Wabbit value. The checked type says which field holds
+// it: Float for float, Int for int, char and bool (0 or 1).
+type Value struct {
+	Int   int
+	Float float64
+}
+
+// Format gives the value as Wabbit source, typ is its checked type
+func (v Value) Format(typ string) string {
+	switch typ {
+	case "float":
+		s := strconv.FormatFloat(v.Float, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEnI") {
+			s += ".0"
+		}
+		return s
+	case "bool":
+		return strconv.FormatBool(v.Int != 0)
+	case "char":
+		return strconv.QuoteRune(rune(v.Int))
+	}
+	return strconv.Itoa(v.Int)
+}
+
+// ValueOf gives x, the Go value of a Wabbit value of typ, as a Value. The
+// Go types are int64 (or int) for int, float64, bool and rune for char.
+func ValueOf(typ string, x interface{}) (Value, bool) {
+	switch v := x.(type) {
+	case int64:
+		return Value{Int: int(v)}, typ == "int"
+	case int:
+		return Value{Int: v}, typ == "int"
+	case float64:
+		return Value{Float: v}, typ == "float"
+	case bool:
+		return boolValue(v), typ == "bool"
+	case rune:
+		return Value{Int: int(v)}, typ == "char"
+	}
+	return Value{}, false
+}
+
+// Go gives v of type typ as a Go value, see ValueOf
+func (v Value) Go(typ string) interface{} {
+	switch typ {
+	case "float":
+		return v.Float
+	case "bool":
+		return v.Int != 0
+	case "char":
+		return rune(v.Int)
+	}
+	return int64(v.Int)
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return Value{Int: 1}
+	}
+	return Value{}
+}
+
+// node is a statement or expression with its names resolved to frame slots
+type node struct {
+	op     op
+	source model.Node // what it was resolved from
+	value  Value      // opConst
+	up     int        // opLoad, opStore and opCall: frames to go up from the current one
+	index  int        // opLoad, opStore: slot in that frame
+	x, y   *node      // operands, the value of a store or return, the test of if and while
+	body   []*node    // if, while and compound
+	orelse []*node    // else
+	args   []*node
+	fn     *function
+	host   *model.HostFunction // opHostCall
+	vars   *variable           // in scope before it, for debuggers
+}
+
+type function struct {
+	name   string
+	params int
+	slots  int // parameters first, then every local of the body
+	body   []*node
+	source *model.FunctionDeclaration
+}
+
+// binding is what a name resolves to
+type binding struct {
+	kind  string // "var", "func", "host" or "type"
+	depth int    // of the frame holding the variable, 0 for globals
+	index int
+	fn    *function
+	host  *model.HostFunction
+}
+
+// variable is a declaration in scope, the ones before it follow next
+type variable struct {
+	name  string
+	typ   string
+	depth int
+	index int
+	next  *variable
+}
+
+// Resolver gives every declaration a (depth, index) slot. Depth 0 is the
+// frame of the globals, every function call gets a frame of its own. Blocks
+// don't have frames, their locals get more slots of the enclosing frame.
+type Resolver struct {
+	program *model.Program
+	env     *common.ChainMap
+	depth   int
+	slots   *int      // slots taken in the frame being resolved
+	vars    *variable // in scope, the last declared first
+}
+
+func NewResolver(program *model.Program) *Resolver {
+	universe := common.NewChainMap()
+	for _, name := range []string{"int", "float", "char", "bool"} {
+		universe.SetValue(name, &binding{kind: "type"})
+	}
+	for _, f := range program.Host.Functions() {
+		universe.SetValue(f.Name, &binding{kind: "host", host: f})
+	}
+	return &Resolver{program: program, env: universe.NewChild(), slots: new(int)}
+}
+
+func (r *Resolver) NewScope(do func()) {
+	oldEnv, oldVars := r.env, r.vars
+	r.env = r.env.NewChild()
+	defer func() {
+		r.env, r.vars = oldEnv, oldVars
+	}()
+	do()
+}
+
+// declare gives name of type typ the next slot of the current frame
+func (r *Resolver) declare(name string, typ string) int {
+	index := *r.slots
+	*r.slots++
+	r.env.SetValue(name, &binding{kind: "var", depth: r.depth, index: index})
+	r.vars = &variable{name: name, typ: typ, depth: r.depth, index: index, next: r.vars}
+	return index
+}
+
+func (r *Resolver) lookup(name string) *binding {
+	b, ok := r.env.GetValue(name)
+	if !ok {
+		// the checker let an undefined name through
+		panic(fmt.Sprintf("undefined name %s", name))
+	}
+	return b.(*binding)
+}
+
+// Resolve gives the statements of the checked program and the number of global slots
+func Resolve(program *model.Program) ([]*node, int) {
+	r := NewResolver(program)
+	body := resolveStatements(program.Model.(*model.Statements), r)
+	return body, *r.slots
+}
+
+func resolveStatements(statements *model.Statements, r *Resolver) []*node {
+	var nodes []*node
+	for _, statement := range statements.Statements {
+		nodes = append(nodes, resolveNode(statement, r))
+	}
+	return nodes
+}
+
+func resolveBlock(statements *model.Statements, r *Resolver) []*node {
+	var nodes []*node
+	r.NewScope(func() {
+		nodes = resolveStatements(statements, r)
+	})
+	return nodes
+}
+
+// typed picks the int or the float version of an operation
+func typed(typ string, intOp op, floatOp op) op {
+	if typ == "float" {
+		return floatOp
+	}
+	return intOp
+}
+
+func resolveNode(source model.Node, r *Resolver) *node {
+	n := &node{source: source, vars: r.vars}
+	switch v := source.(type) {
+	case *model.Integer:
+		n.op, n.value = opConst, Value{Int: v.Value}
+	case *model.Float:
+		n.op, n.value = opConst, Value{Float: v.Value}
+	case *model.Character:
+		unquoted, err := strconv.Unquote(v.Value)
+		if err != nil {
+			panic(err)
+		}
+		n.op, n.value = opConst, Value{Int: int(unquoted[0])}
+	case *model.NameBool:
+		n.op, n.value = opConst, boolValue(v.Name == "true")
+	case *model.Name:
+		b := r.lookup(v.Text)
+		n.op, n.up, n.index = opLoad, r.depth-b.depth, b.index
+	case *model.Grouping:
+		return resolveNode(v.Expression, r)
+	case *model.Pos:
+		return resolveNode(v.Operand, r)
+
+	case *model.Add:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v), opAddInt, opAddFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Sub:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v), opSubInt, opSubFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Mul:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v), opMulInt, opMulFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Div:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v), opDivInt, opDivFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Neg:
+		n.op, n.x = typed(r.program.TypeOf(v), opNegInt, opNegFloat), resolveNode(v.Operand, r)
+	case *model.Not:
+		n.op, n.x = opNot, resolveNode(v.Operand, r)
+	case *model.Lt:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v.Left), opLtInt, opLtFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Le:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v.Left), opLeInt, opLeFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Gt:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v.Left), opGtInt, opGtFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Ge:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v.Left), opGeInt, opGeFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Eq:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v.Left), opEqInt, opEqFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.Ne:
+		n.op, n.x, n.y = typed(r.program.TypeOf(v.Left), opNeInt, opNeFloat), resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.LogAnd:
+		n.op, n.x, n.y = opAnd, resolveNode(v.Left, r), resolveNode(v.Right, r)
+	case *model.LogOr:
+		n.op, n.x, n.y = opOr, resolveNode(v.Left, r), resolveNode(v.Right, r)
+
+	case *model.Assignment:
+		n.x = resolveNode(v.Value, r)
+		b := r.lookup(v.Location.(*model.Name).Text)
+		n.op, n.up, n.index = opStore, r.depth-b.depth, b.index
+	case *model.VarDeclaration:
+		if v.Value != nil {
+			n.x = resolveNode(v.Value, r)
+		} else {
+			n.x = &node{op: opConst, source: v}
+		}
+		// the value is resolved first, var x = x; reads an outer x
+		n.op, n.index = opStore, r.declare(v.Name.Text, r.program.TypeOf(v))
+	case *model.ConstDeclaration:
+		n.x = resolveNode(v.Value, r)
+		n.op, n.index = opStore, r.declare(v.Name.Text, r.program.TypeOf(v))
+
+	case *model.FunctionApplication:
+		name := v.Func.(*model.Name).Text
+		b := r.lookup(name)
+		for _, arg := range v.Arguments {
+			n.args = append(n.args, resolveNode(arg, r))
+		}
+		if b.kind == "type" {
+			// a conversion, only int and float differ in representation
+			from, to := r.program.TypeOf(v.Arguments[0]), name
+			switch {
+			case from == "float" && to != "float":
+				n.op, n.x = opFloatToInt, n.args[0]
+			case from != "float" && to == "float":
+				n.op, n.x = opIntToFloat, n.args[0]
+			default:
+				return n.args[0]
+			}
+			n.args = nil
+			return n
+		}
+		if b.kind == "host" {
+			n.op, n.host = opHostCall, b.host
+			return n
+		}
+		n.op, n.up, n.fn = opCall, r.depth-b.depth, b.fn
+	case *model.CompoundExpression:
+		statements := v.Statements.Statements
+		r.NewScope(func() {
+			for _, statement := range statements[:len(statements)-1] {
+				n.body = append(n.body, resolveNode(statement, r))
+			}
+			// the checker made sure the last one is an expression
+			n.x = resolveNode(statements[len(statements)-1].(*model.ExpressionAsStatement).Expression, r)
+		})
+		n.op = opCompound
+
+	case *model.PrintStatement:
+		n.x = resolveNode(v.Value, r)
+		switch r.program.TypeOf(v.Value) {
+		case "float":
+			n.op = opPrintFloat
+		case "bool":
+			n.op = opPrintBool
+		case "char":
+			n.op = opPrintChar
+		default:
+			n.op = opPrintInt
+		}
+	case *model.ExpressionAsStatement:
+		n.op, n.x = opExpr, resolveNode(v.Expression, r)
+	case *model.IfStatement:
+		n.op, n.x = opIf, resolveNode(v.Test, r)
+		n.body = resolveBlock(&v.Consequence, r)
+		if v.Alternative != nil {
+			n.orelse = resolveBlock(v.Alternative, r)
+		}
+	case *model.WhileStatement:
+		n.op, n.x = opWhile, resolveNode(v.Test, r)
+		n.body = resolveBlock(&v.Body, r)
+	case *model.BreakStatement:
+		n.op = opBreak
+	case *model.ContinueStatement:
+		n.op = opContinue
+	case *model.ReturnStatement:
+		n.op, n.x = opReturn, resolveNode(v.Value, r)
+		if n.x.op == opCall {
+			n.op = opTailCall
+		}
+
+	case *model.FunctionDeclaration:
+		fn := &function{name: v.Name.Text, params: len(v.Parameters), source: v}
+		// declared before the body is resolved so it can call itself
+		r.env.SetValue(v.Name.Text, &binding{kind: "func", depth: r.depth, fn: fn})
+		oldSlots := r.slots
+		r.slots = &fn.slots
+		r.depth++
+		r.NewScope(func() {
+			for _, param := range v.Parameters {
+				r.declare(param.Name.Text, param.Type.Type())
+			}
+			fn.body = resolveStatements(&v.Body, r)
+		})
+		r.depth--
+		r.slots = oldSlots
+		n.op = opNop
+	default:
+		panic(fmt.Sprintf("Can't resolve %#v", v))
+	}
+	return n
+}
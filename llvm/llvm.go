@@ -58,6 +58,7 @@ func (f *Function) String() string {
 }
 
 type Context struct {
+	program  *model.Program
 	N        int
 	nlabels  int
 	globals  []string
@@ -76,6 +77,11 @@ func (ctx *Context) NewLabel() string {
 	return fmt.Sprintf("\".%d\"", ctx.N)
 }
 
+// TypeOf gives the type the checker recorded for node
+func (ctx *Context) TypeOf(node model.Node) string {
+	return ctx.program.TypeOf(node)
+}
+
 func (ctx *Context) Define(name string, value *LValue) {
 	ctx.env.SetValue(name, value)
 }
@@ -100,7 +106,8 @@ func (ctx *Context) NewScope(do func()) {
 
 func LLVM(program *model.Program) string {
 	context := &Context{
-		N: 0,
+		program: program,
+		N:       0,
 		globals: []string{
 			"declare void @\"_printi\"(i64 %\".1\")",
 			"declare void @\"_printf\"(double %\".1\")",
@@ -116,6 +123,15 @@ func LLVM(program *model.Program) string {
 		env:   common.NewChainMap(),
 		scope: "global",
 	}
+	// host functions are linked in like the runtime
+	for _, f := range program.Host.Functions() {
+		params := make([]string, len(f.Params))
+		for i, param := range f.Params {
+			params[i] = fmt.Sprintf("%s %%\".%d\"", _typemap[param], i+1)
+		}
+		context.globals = append(context.globals, fmt.Sprintf("declare %s @\"%s\"(%s)",
+			_typemap[f.Result], f.Name, strings.Join(params, ", ")))
+	}
 	context.function.code = append(context.function.code, "entry:")
 	_ = InterpretNode(program.Model, context) // generate is InterpretNode in the same meaning
 	context.function.code = append(context.function.code, "ret i64 0")
@@ -179,83 +195,67 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		// we should check the type of left and right go we can't make interface + interface
-		if left.WType == "int" && right.WType == "int" {
-			context.function.code = append(context.function.code,
-				fmt.Sprintf("%s = add i64 %s, %s", val, left.LValue, right.LValue))
-
-		} else if left.WType == "float" && right.WType == "float" {
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code,
 				fmt.Sprintf("%s = fadd double %s, %s", val, left.LValue, right.LValue))
 		} else {
-			panic("type different")
+			context.function.code = append(context.function.code,
+				fmt.Sprintf("%s = add i64 %s, %s", val, left.LValue, right.LValue))
 		}
-		return &LValue{left.WType, val, ""}
+		return &LValue{typ, val, ""}
 	case *model.Mul:
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		// we should check the type of left and right go we can't make interface + interface
-		if left.WType == "int" && right.WType == "int" {
-			context.function.code = append(context.function.code,
-				fmt.Sprintf("%s = mul i64 %s, %s", val, left.LValue, right.LValue))
-
-		} else if left.WType == "float" && right.WType == "float" {
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code,
 				fmt.Sprintf("%s = fmul double %s, %s", val, left.LValue, right.LValue))
 		} else {
-			panic("type different")
+			context.function.code = append(context.function.code,
+				fmt.Sprintf("%s = mul i64 %s, %s", val, left.LValue, right.LValue))
 		}
-		return &LValue{left.WType, val, ""}
+		return &LValue{typ, val, ""}
 	case *model.Sub:
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		// we should check the type of left and right go we can't make interface + interface
-		if left.WType == "int" && right.WType == "int" {
-			context.function.code = append(context.function.code,
-				fmt.Sprintf("%s = sub i64 %s, %s", val, left.LValue, right.LValue))
-
-		} else if left.WType == "float" && right.WType == "float" {
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code,
 				fmt.Sprintf("%s = fsub double %s, %s", val, left.LValue, right.LValue))
 		} else {
-			panic("type different")
+			context.function.code = append(context.function.code,
+				fmt.Sprintf("%s = sub i64 %s, %s", val, left.LValue, right.LValue))
 		}
-		return &LValue{left.WType, val, ""}
+		return &LValue{typ, val, ""}
 	case *model.Div:
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		// we should check the type of left and right go we can't make interface + interface
-		if left.WType == "int" && right.WType == "int" {
-			context.function.code = append(context.function.code,
-				fmt.Sprintf("%s = sdiv i64 %s, %s", val, left.LValue, right.LValue))
-
-		} else if left.WType == "float" && right.WType == "float" {
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code,
 				fmt.Sprintf("%s = fdiv double %s, %s", val, left.LValue, right.LValue))
 		} else {
-			panic("type different")
+			context.function.code = append(context.function.code,
+				fmt.Sprintf("%s = sdiv i64 %s, %s", val, left.LValue, right.LValue))
 		}
-		return &LValue{left.WType, val, ""}
+		return &LValue{typ, val, ""}
 
 	case *model.Neg:
 		right := InterpretNode(v.Operand, context)
 		val := context.NewRegister()
-		if right.WType == "int" {
-			context.function.code = append(context.function.code,
-				fmt.Sprintf("%s = sub i64 0, %s", val, right.LValue))
-
-		} else if right.WType == "float" {
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code,
 				fmt.Sprintf("%s = fsub double 0x0, %s", val, right.LValue)) // or fneg double
 		} else {
-			// we think it's a type error
-			//return &WabbitValue{"error", "type error"}
-			panic("type different")
+			context.function.code = append(context.function.code,
+				fmt.Sprintf("%s = sub i64 0, %s", val, right.LValue))
 		}
-		return &LValue{right.WType, val, context.scope}
+		return &LValue{typ, val, context.scope}
 
 	case *model.Pos:
 		right := InterpretNode(v.Operand, context)
@@ -263,23 +263,16 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 	case *model.Not:
 		right := InterpretNode(v.Operand, context)
 		val := context.NewRegister()
-		if right.WType == "bool" {
-			context.function.code = append(context.function.code,
-				fmt.Sprintf("%s = xor i1 1, %s", val, right.LValue))
-		} else {
-			panic("type different")
-		}
-		return &LValue{right.WType, val, ""}
+		context.function.code = append(context.function.code,
+			fmt.Sprintf("%s = xor i1 1, %s", val, right.LValue))
+		return &LValue{"bool", val, ""}
 	case *model.VarDeclaration:
 		// make value number like llvmlite
 		context.N++
 		var val *LValue
-		var valtype string
+		valtype := context.TypeOf(v)
 		if v.Value != nil {
 			val = InterpretNode(v.Value, context) // store in stack
-			valtype = val.WType
-		} else {
-			valtype = v.Type.Type()
 		}
 		if context.scope == "global" {
 			context.globals = append(context.globals,
@@ -317,13 +310,9 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		//var val *WVMVar
 		context.N++
 		var val *LValue
-		var valtype string
+		valtype := context.TypeOf(v)
 		if v.Value != nil {
 			val = InterpretNode(v.Value, context) // store in stack
-			valtype = val.WType
-		} else {
-			valtype = v.Type.Type()
-			//val = &LValue{valtype, "0", ""}
 		}
 		if context.scope == "global" {
 			context.globals = append(context.globals,
@@ -359,8 +348,8 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		ltype := _typemap[left.WType]
-		if left.WType == "float" {
+		ltype := _typemap[context.TypeOf(v.Left)]
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, fmt.Sprintf("%s = fcmp olt double %s, %s", val, left.LValue, right.LValue))
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -371,8 +360,8 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		ltype := _typemap[left.WType]
-		if left.WType == "float" {
+		ltype := _typemap[context.TypeOf(v.Left)]
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, fmt.Sprintf("%s = fcmp ole double %s, %s", val, left.LValue, right.LValue))
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -383,8 +372,8 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		ltype := _typemap[left.WType]
-		if left.WType == "float" {
+		ltype := _typemap[context.TypeOf(v.Left)]
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, fmt.Sprintf("%s = fcmp ogt double %s, %s", val, left.LValue, right.LValue))
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -395,8 +384,8 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		ltype := _typemap[left.WType]
-		if left.WType == "float" {
+		ltype := _typemap[context.TypeOf(v.Left)]
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, fmt.Sprintf("%s = fcmp oge double %s, %s", val, left.LValue, right.LValue))
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -407,8 +396,8 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		ltype := _typemap[left.WType]
-		if left.WType == "float" {
+		ltype := _typemap[context.TypeOf(v.Left)]
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, fmt.Sprintf("%s = fcmp oeq double %s, %s", val, left.LValue, right.LValue))
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -419,8 +408,8 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		left := InterpretNode(v.Left, context)
 		right := InterpretNode(v.Right, context)
 		val := context.NewRegister()
-		ltype := _typemap[left.WType]
-		if left.WType == "float" {
+		ltype := _typemap[context.TypeOf(v.Left)]
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, fmt.Sprintf("%s = fcmp one double %s, %s", val, left.LValue, right.LValue))
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -484,7 +473,7 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		val := InterpretNode(v.Value, context)
 		context.N++
 		// assign the value to the name
-		ltype := _typemap[val.WType]
+		ltype := _typemap[context.TypeOf(v)]
 		decl := context.Lookup(v.Location.(*model.Name).Text)
 		context.function.code = append(context.function.code,
 			fmt.Sprintf("store %s %s, %s* %s", ltype, val.LValue, ltype, decl.LValue))
@@ -494,7 +483,7 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 	case *model.PrintStatement:
 		value := InterpretNode(v.Value, context)
 		context.N++
-		ltype := value.WType
+		ltype := context.TypeOf(v.Value)
 		switch ltype {
 		case "char":
 			context.function.code = append(context.function.code,
@@ -558,7 +547,7 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 	case *model.ReturnStatement:
 		value := InterpretNode(v.Value, context)
 		context.function.code = append(context.function.code,
-			fmt.Sprintf("ret %s %s", _typemap[value.WType], value.LValue))
+			fmt.Sprintf("ret %s %s", _typemap[context.function.retType], value.LValue))
 		return value
 	//
 	case *model.WhileStatement:
@@ -631,12 +620,16 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		if name == "int" {
 			// only float need to cast
 			argVal := InterpretNode(v.Arguments[0], context)
+			argType := context.TypeOf(v.Arguments[0])
 			var result string
-			if argVal.WType == "float" {
+			if argType == "float" {
+				result = context.NewRegister()
+				context.function.code = append(context.function.code,
+					fmt.Sprintf("%s = fptosi double %s to i64", result, argVal.LValue))
+			} else if argType == "char" {
 				result = context.NewRegister()
-				ltype := _typemap[argVal.WType]
 				context.function.code = append(context.function.code,
-					fmt.Sprintf("%s = fptosi %s %s to i64", result, ltype, argVal.LValue))
+					fmt.Sprintf("%s = zext i8 %s to i64", result, argVal.LValue))
 			} else {
 				result = argVal.LValue
 			}
@@ -647,10 +640,11 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		}
 		if name == "float" {
 			argVal := InterpretNode(v.Arguments[0], context)
+			argType := context.TypeOf(v.Arguments[0])
 			var result string
-			if argVal.WType != "float" {
+			if argType != "float" {
 				result = context.NewRegister()
-				ltype := _typemap[argVal.WType]
+				ltype := _typemap[argType]
 				context.function.code = append(context.function.code,
 					fmt.Sprintf("%s = sitofp %s %s to double", result, ltype, argVal.LValue))
 			} else {
@@ -663,10 +657,11 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		}
 		if name == "char" {
 			argVal := InterpretNode(v.Arguments[0], context)
+			argType := context.TypeOf(v.Arguments[0])
 			var result string
-			if argVal.WType != "char" {
+			if argType != "char" {
 				result = context.NewRegister()
-				ltype := _typemap[argVal.WType]
+				ltype := _typemap[argType]
 				context.function.code = append(context.function.code,
 					fmt.Sprintf("%s = trunc %s %s to i8", result, ltype, argVal.LValue))
 			} else {
@@ -683,18 +678,17 @@ func InterpretNode(node model.Node, context *Context) *LValue {
 		var argValuesStr []string
 		for _, arg := range v.Arguments {
 			argVal := InterpretNode(arg, context)
-			argValues = append(argValues)
+			argValues = append(argValues, argVal)
 			argValuesStr = append(argValuesStr, fmt.Sprintf("%s %s",
-				_typemap[argVal.WType], argVal.LValue))
+				_typemap[context.TypeOf(arg)], argVal.LValue))
 		}
-		funcVar := context.Lookup(v.Func.(*model.Name).Text)
 		funcName := fmt.Sprintf("@\"%s\"", v.Func.(*model.Name).Text)
 		context.function.code = append(context.function.code,
-			fmt.Sprintf("%s = call %s %s(%s)", result, _typemap[funcVar.WType], funcName,
+			fmt.Sprintf("%s = call %s %s(%s)", result, _typemap[context.TypeOf(v)], funcName,
 				strings.Join(argValuesStr, ", ")))
 
 		return &LValue{
-			WType:  funcVar.WType,
+			WType:  context.TypeOf(v),
 			LValue: result,
 		}
 
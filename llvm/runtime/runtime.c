@@ -0,0 +1,52 @@
+/* For LLVM, you need some runtime functions to produce ouput.  Use
+   these and include them in final compilation with clang.
+
+   Output must match the interpreter and the WVM byte for byte, so
+   numbers print the way Go's fmt.Println prints them. */
+
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+void _printi(long long x) {
+  printf("%lld\n", x);
+}
+
+/* Shortest digits that read back as x, in exponent form when the
+   exponent is below -4 or at least 6, like Go's %v. */
+void _printf(double x) {
+  char buf[64];
+  int precision, exp;
+  for (precision = 1; precision < 17; precision++) {
+    snprintf(buf, sizeof buf, "%.*e", precision - 1, x);
+    if (strtod(buf, NULL) == x) {
+      break;
+    }
+  }
+  snprintf(buf, sizeof buf, "%.*e", precision - 1, x);
+  exp = atoi(strchr(buf, 'e') + 1);
+  if (exp < -4 || exp >= 6) {
+    /* Go writes at least two exponent digits, as C does */
+    printf("%s\n", buf);
+  } else {
+    int decimals = precision - 1 - exp;
+    printf("%.*f\n", decimals > 0 ? decimals : 0, x);
+  }
+}
+
+void _printb(int x) {
+  if (x) {
+    printf("true\n");
+  } else {
+    printf("false\n");
+  }
+}
+
+void _printc(char c) {
+  printf("%c", c);
+  fflush(stdout);
+}
+
+void _printu() {
+  printf("()\n");
+}
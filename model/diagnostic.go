@@ -0,0 +1,154 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	default:
+		return "error"
+	}
+}
+
+// Span is a half open range of byte offsets into the source
+type Span struct {
+	Start int
+	End   int
+}
+
+// Diagnostic is a problem found in a program by the tokenizer, parser or checker
+type Diagnostic struct {
+	Severity  Severity
+	Code      string
+	Message   string
+	File      string
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+	Span      Span
+	Notes     []string
+}
+
+// NewDiagnostic makes an error pointing at loc
+func NewDiagnostic(loc Locator, code string, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{
+		Severity:  SeverityError,
+		Code:      code,
+		Message:   fmt.Sprintf(format, args...),
+		File:      loc.File,
+		Line:      loc.StartPos.Line,
+		Column:    loc.StartPos.Column,
+		EndLine:   loc.EndPos.Line,
+		EndColumn: loc.EndPos.Column,
+		Span:      Span{loc.Start, loc.End},
+	}
+}
+
+func (d Diagnostic) header() string {
+	if d.Code == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s[%s]: %s", d.Severity, d.Code, d.Message)
+}
+
+func (d Diagnostic) position() string {
+	file := d.File
+	if file == "" {
+		file = "<input>"
+	}
+	return fmt.Sprintf("%s:%d:%d", file, d.Line, d.Column)
+}
+
+// Range gives line:col-endline:endcol
+func (d Diagnostic) Range() string {
+	return fmt.Sprintf("%d:%d-%d:%d", d.Line, d.Column, d.EndLine, d.EndColumn)
+}
+
+// Error gives the one line form, file:line:col: error[code]: message
+func (d Diagnostic) Error() string {
+	return d.position() + ": " + d.header()
+}
+
+// Render gives the long form with the offending line and a ^^^ underline
+//
+//	error[E0301]: undefined name 'x'
+//	 --> test.wb:3:7
+//	  |
+//	3 | print x;
+//	  |       ^
+//	  = note: ...
+func (d Diagnostic) Render(source string) string {
+	var sb strings.Builder
+	sb.WriteString(d.header() + "\n")
+
+	gutter := strings.Repeat(" ", len(strconv.Itoa(d.Line)))
+	sb.WriteString(fmt.Sprintf("%s--> %s\n", gutter, d.position()))
+	if d.Span.Start >= 0 && d.Span.Start <= len(source) && source != "" {
+		loc := Locator{SourceCode: source, Start: d.Span.Start, End: d.Span.End}
+		lines := strings.Split(loc.LineContext(0, 0), "\n")
+		sb.WriteString(fmt.Sprintf("%s |\n", gutter))
+		sb.WriteString(fmt.Sprintf("%d | %s\n", d.Line, lines[0]))
+		sb.WriteString(fmt.Sprintf("%s | %s\n", gutter, lines[1]))
+	}
+	for _, note := range d.Notes {
+		sb.WriteString(fmt.Sprintf("%s = note: %s\n", gutter, note))
+	}
+	return sb.String()
+}
+
+// Diagnostics lets a whole batch travel as one error
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	var lines []string
+	for _, d := range ds {
+		lines = append(lines, d.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderError renders diagnostics carried by err against source, other errors as is
+func RenderError(err error, source string) string {
+	switch e := err.(type) {
+	case Diagnostic:
+		return e.Render(source)
+	case Diagnostics:
+		var sb strings.Builder
+		for i, d := range e {
+			if i > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(d.Render(source))
+		}
+		return sb.String()
+	case *RuntimeError:
+		return e.Render(source)
+	default:
+		return err.Error() + "\n"
+	}
+}
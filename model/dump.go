@@ -0,0 +1,60 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// DumpNode gives node as an indented tree, one node per line. With a program
+// the recorded position of every node is shown too.
+func DumpNode(node Node, program *Program) string {
+	var sb strings.Builder
+	dumpValue(&sb, reflect.ValueOf(node), "", "", program)
+	return sb.String()
+}
+
+func isScalar(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Int, reflect.Int64, reflect.Float64, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+func dumpValue(sb *strings.Builder, v reflect.Value, indent string, label string, program *Program) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			dumpValue(sb, v.Index(i), indent, fmt.Sprintf("%s[%d]", strings.TrimSuffix(label, ": "), i)+": ", program)
+		}
+		return
+	}
+	if v.Kind() != reflect.Struct {
+		return
+	}
+	sb.WriteString(indent + label + v.Type().Name())
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); isScalar(field) {
+			fmt.Fprintf(sb, " %s=%#v", v.Type().Field(i).Name, field.Interface())
+		}
+	}
+	if program != nil && v.CanAddr() {
+		if node, ok := v.Addr().Interface().(Node); ok {
+			if loc, ok := program.Db[node.Id()]; ok {
+				fmt.Fprintf(sb, " @%s-%s", loc.StartPos, loc.EndPos)
+			}
+		}
+	}
+	sb.WriteString("\n")
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); !isScalar(field) {
+			dumpValue(sb, field, indent+"  ", v.Type().Field(i).Name+": ", program)
+		}
+	}
+}
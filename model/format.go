@@ -0,0 +1,159 @@
+package model
+
+import "strings"
+
+// Format prints a parsed program in the standard layout. Comments are kept,
+// runs of blank lines between statements become a single one.
+func Format(program *Program) string {
+	context := &Context{
+		program:  program,
+		comments: &comments{list: program.Comments},
+		end:      len(program.Source),
+	}
+	source := NodeAsSource(program.Model, context)
+	if source == "" {
+		return ""
+	}
+	return source + "\n"
+}
+
+// comments are handed out in source order while the program is printed
+type comments struct {
+	list []Comment
+	next int
+	line int // source line of the last thing printed, 0 at the start of a block
+}
+
+// block gives the context printing a block closed by the '}' at end
+func (c *Context) block(end int) *Context {
+	inner := c.NewBlock()
+	inner.end = end
+	return inner
+}
+
+func appendBlock(lines []string, body string) []string {
+	if body == "" {
+		return lines
+	}
+	return append(lines, body)
+}
+
+func (c *Context) location(node Node) (Locator, bool) {
+	if c.program == nil {
+		return Locator{}, false
+	}
+	loc, ok := c.program.Db[node.Id()]
+	return loc, ok
+}
+
+func (c *Context) startBlock() {
+	if c.comments != nil {
+		c.comments.line = 0
+	}
+}
+
+// gap gives the blank line kept before something on line
+func (c *Context) gap(line int) []string {
+	if c.comments.line > 0 && line > c.comments.line+1 {
+		return []string{""}
+	}
+	return nil
+}
+
+// commentsBefore gives the lines of the comments not printed yet that start before offset
+func (c *Context) commentsBefore(offset int) []string {
+	if c.comments == nil {
+		return nil
+	}
+	var lines []string
+	for cs := c.comments; cs.next < len(cs.list) && cs.list[cs.next].Pos.Offset < offset; cs.next++ {
+		comment := cs.list[cs.next]
+		lines = append(lines, c.gap(comment.Pos.Line)...)
+		lines = append(lines, c.Indent+comment.Text)
+		cs.line = comment.End.Line
+	}
+	return lines
+}
+
+// before gives the lines going ahead of statement: comments and a blank line
+func (c *Context) before(statement Node) []string {
+	loc, ok := c.location(statement)
+	if !ok || c.comments == nil {
+		return nil
+	}
+	lines := c.commentsBefore(loc.Start)
+	return append(lines, c.gap(loc.StartPos.Line)...)
+}
+
+// after gives a comment on the line statement ends on, to print on its line
+func (c *Context) after(statement Node) string {
+	loc, ok := c.location(statement)
+	if !ok || c.comments == nil {
+		return ""
+	}
+	cs := c.comments
+	cs.line = loc.EndPos.Line
+	if cs.next < len(cs.list) {
+		comment := cs.list[cs.next]
+		if comment.Pos.Line == loc.EndPos.Line && comment.End.Line == comment.Pos.Line {
+			cs.next++
+			return " " + comment.Text
+		}
+	}
+	return ""
+}
+
+// closing gives the offset of the '}' ending a statement with a block
+func (c *Context) closing(statement Node) int {
+	loc, ok := c.location(statement)
+	if !ok {
+		return 0
+	}
+	return loc.End - 1
+}
+
+// consequenceEnd gives the offset of the '}' before the else
+func (c *Context) consequenceEnd(v *IfStatement) int {
+	if v.Alternative == nil {
+		return c.closing(v)
+	}
+	if n := len(v.Consequence.Statements); n > 0 {
+		if loc, ok := c.location(v.Consequence.Statements[n-1]); ok {
+			return c.skip(loc.End)
+		}
+		return 0
+	}
+	loc, ok := c.location(v.Test)
+	if !ok {
+		return 0
+	}
+	// past the '{'
+	return c.skip(c.skip(loc.End) + 1)
+}
+
+// skip gives the offset of the first token at or after offset
+func (c *Context) skip(offset int) int {
+	source := c.program.Source
+	for offset < len(source) {
+		rest := source[offset:]
+		switch {
+		case strings.IndexByte(" \t\r\n", rest[0]) >= 0:
+			offset++
+		case strings.HasPrefix(rest, "//"):
+			if n := strings.IndexByte(rest, '\n'); n >= 0 {
+				offset += n
+			} else {
+				offset = len(source)
+			}
+		case strings.HasPrefix(rest, "/*"):
+			if n := strings.Index(rest[2:], "*/"); n >= 0 {
+				offset += n + 4
+			} else {
+				offset = len(source)
+			}
+		default:
+			return offset
+		}
+	}
+	return offset
+}
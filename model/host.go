@@ -0,0 +1,107 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// HostFunc is the Go side of a host function. Args and the result are Go
+// values of the Wabbit types: int64 for int, float64 for float, bool for
+// bool and rune for char. An error stops the program at the call.
+type HostFunc func(args []interface{}) (interface{}, error)
+
+// HostFunction is a function of the host programs can call like their own
+type HostFunction struct {
+	Name   string
+	Params []string
+	Result string
+	Func   HostFunc
+}
+
+// Signature gives f the way it was registered, sqrt(float) float
+func (f *HostFunction) Signature() string {
+	return fmt.Sprintf("%s(%s) %s", f.Name, strings.Join(f.Params, ", "), f.Result)
+}
+
+// Host is the registry of the host functions of a program. The checker
+// checks calls against their signatures, interp and wvm call their Func,
+// wasm imports them from env and llvm declares them for the linker.
+type Host struct {
+	functions map[string]*HostFunction
+	order     []*HostFunction
+}
+
+func NewHost() *Host {
+	return &Host{functions: make(map[string]*HostFunction)}
+}
+
+var hostTypes = map[string]bool{"int": true, "float": true, "bool": true, "char": true}
+
+// Register adds the host function of signature, like sqrt(float) float or
+// read_int() int, fn runs it for interp and wvm
+func (h *Host) Register(signature string, fn HostFunc) error {
+	f, err := ParseSignature(signature)
+	if err != nil {
+		return fmt.Errorf("host function %q: %v", signature, err)
+	}
+	if _, ok := h.functions[f.Name]; ok {
+		return fmt.Errorf("host function '%s' already registered", f.Name)
+	}
+	f.Func = fn
+	h.functions[f.Name] = f
+	h.order = append(h.order, f)
+	return nil
+}
+
+// ParseSignature gives the function of signature, without a Func
+func ParseSignature(signature string) (*HostFunction, error) {
+	open, close := strings.Index(signature, "("), strings.Index(signature, ")")
+	if open < 0 || close < open {
+		return nil, fmt.Errorf("want name(types) type")
+	}
+	f := &HostFunction{
+		Name:   strings.TrimSpace(signature[:open]),
+		Result: strings.TrimSpace(signature[close+1:]),
+	}
+	if !isIdentifier(f.Name) || hostTypes[f.Name] {
+		return nil, fmt.Errorf("bad name '%s'", f.Name)
+	}
+	if params := strings.TrimSpace(signature[open+1 : close]); params != "" {
+		for _, param := range strings.Split(params, ",") {
+			f.Params = append(f.Params, strings.TrimSpace(param))
+		}
+	}
+	for _, typ := range append(f.Params, f.Result) {
+		if !hostTypes[typ] {
+			return nil, fmt.Errorf("unknown type '%s'", typ)
+		}
+	}
+	return f, nil
+}
+
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Lookup gives the host function name, nil when there is none. A nil Host
+// has none.
+func (h *Host) Lookup(name string) *HostFunction {
+	if h == nil {
+		return nil
+	}
+	return h.functions[name]
+}
+
+// Functions gives the host functions in the order they were registered
+func (h *Host) Functions() []*HostFunction {
+	if h == nil {
+		return nil
+	}
+	return h.order
+}
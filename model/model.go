@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 //type Node struct {
@@ -44,8 +45,11 @@ var staticID = 0
 //	return self
 //}
 
+// NodeManager gives every node an id. Programs are parsed and run from
+// several goroutines, so the map is behind a lock.
 type NodeManager struct {
 	NodeMap map[Node]NodeInfo
+	lock    sync.RWMutex
 }
 
 var TheNodeManager NodeManager
@@ -53,11 +57,13 @@ var TheNodeManager NodeManager
 func init() {
 	// Ugly Now
 	TheNodeManager = NodeManager{
-		make(map[Node]NodeInfo),
+		NodeMap: make(map[Node]NodeInfo),
 	}
 }
 
 func RegisterNode(node Node) {
+	TheNodeManager.lock.Lock()
+	defer TheNodeManager.lock.Unlock()
 	staticID++
 	n := NodeInfo{
 		Id: staticID,
@@ -68,19 +74,24 @@ func RegisterNode(node Node) {
 func GetNodeInfo(node Node) NodeInfo {
 	// if node in TheNodeManager return it
 	// else put it with create a nodeinfo
-	if _, ok := TheNodeManager.NodeMap[node]; ok {
-		// return it
-		return TheNodeManager.NodeMap[node]
-	} else {
-		// create a nodeinfo
-		// put it
-		staticID++
-		n := NodeInfo{
-			Id: staticID,
-		}
-		TheNodeManager.NodeMap[node] = n
+	TheNodeManager.lock.RLock()
+	n, ok := TheNodeManager.NodeMap[node]
+	TheNodeManager.lock.RUnlock()
+	if ok {
+		return n
+	}
+	TheNodeManager.lock.Lock()
+	defer TheNodeManager.lock.Unlock()
+	if n, ok := TheNodeManager.NodeMap[node]; ok {
+		// registered while we waited for the lock
 		return n
 	}
+	staticID++
+	n = NodeInfo{
+		Id: staticID,
+	}
+	TheNodeManager.NodeMap[node] = n
+	return n
 }
 
 type Boolean interface {
@@ -452,7 +463,10 @@ type Parameter struct {
 func (n *Parameter) Id() int { return GetNodeInfo(n).Id }
 
 type Context struct {
-	Indent string
+	Indent   string
+	program  *Program // when printing a parsed program, see Format
+	comments *comments
+	end      int // offset of the '}' closing the block being printed
 }
 
 func NewContext() *Context {
@@ -460,7 +474,7 @@ func NewContext() *Context {
 }
 
 func (c *Context) NewBlock() *Context {
-	return &Context{Indent: c.Indent + "    "}
+	return &Context{Indent: c.Indent + "    ", program: c.program, comments: c.comments}
 }
 
 func NodeAsSource(node Node, context *Context) string {
@@ -469,7 +483,18 @@ func NodeAsSource(node Node, context *Context) string {
 	case *Integer:
 		return strconv.Itoa(v.Value)
 	case *Float:
-		return fmt.Sprintf("%v", v.Value)
+		// always with a '.', 3.0 must not come back as the int 3
+		text := strconv.FormatFloat(v.Value, 'f', -1, 64)
+		if !strings.Contains(text, ".") {
+			text += ".0"
+		}
+		return text
+	case *Character:
+		return v.Value
+	case *TrueBool:
+		return "true"
+	case *FalseBool:
+		return "false"
 	case *Name:
 		return v.Text
 	case *NameType:
@@ -527,25 +552,31 @@ func NodeAsSource(node Node, context *Context) string {
 	case *Neg:
 		return fmt.Sprintf("-%s",
 			NodeAsSource(v.Operand, context))
+	case *Pos:
+		return fmt.Sprintf("+%s",
+			NodeAsSource(v.Operand, context))
+	case *Not:
+		return fmt.Sprintf("!%s",
+			NodeAsSource(v.Operand, context))
 
 	case *LogOr:
 		return fmt.Sprintf("%s %s %s",
-			NodeAsSource(v.Left, context), "or",
+			NodeAsSource(v.Left, context), "||",
 			NodeAsSource(v.Right, context))
 	case *LogAnd:
 		return fmt.Sprintf("%s %s %s",
-			NodeAsSource(v.Left, context), "and",
+			NodeAsSource(v.Left, context), "&&",
 			NodeAsSource(v.Right, context))
 	case *Grouping:
 		return fmt.Sprintf("(%s)", NodeAsSource(v.Expression, context))
 	case *ConstDeclaration:
 		if v.Type == nil {
-			return fmt.Sprintf("%sconst %s = %s;",
-				indent_str, NodeAsSource(&v.Name, context),
+			return fmt.Sprintf("const %s = %s;",
+				NodeAsSource(&v.Name, context),
 				NodeAsSource(v.Value, context))
 		} else {
-			return fmt.Sprintf("%sconst %s %s = %s;",
-				indent_str, NodeAsSource(&v.Name, context),
+			return fmt.Sprintf("const %s %s = %s;",
+				NodeAsSource(&v.Name, context),
 				NodeAsSource(v.Type, context),
 				NodeAsSource(v.Value, context))
 		}
@@ -554,28 +585,28 @@ func NodeAsSource(node Node, context *Context) string {
 		for _, p := range v.Arguments {
 			ts = append(ts, fmt.Sprintf("%s", NodeAsSource(p, context)))
 		}
-		return fmt.Sprintf("%s(%s)", NodeAsSource(v.Func, context), strings.Join(ts, " "))
+		return fmt.Sprintf("%s(%s)", NodeAsSource(v.Func, context), strings.Join(ts, ", "))
 	case *VarDeclaration:
 		if v.Value != nil {
 			if v.Type == nil {
-				return fmt.Sprintf("%svar %s = %s;",
-					indent_str, NodeAsSource(&v.Name, context),
+				return fmt.Sprintf("var %s = %s;",
+					NodeAsSource(&v.Name, context),
 					NodeAsSource(v.Value, context))
 			} else {
-				return fmt.Sprintf("%svar %s %s = %s;",
-					indent_str, NodeAsSource(&v.Name, context),
+				return fmt.Sprintf("var %s %s = %s;",
+					NodeAsSource(&v.Name, context),
 					NodeAsSource(v.Type, context),
 					NodeAsSource(v.Value, context))
 			}
 		} else {
-			return fmt.Sprintf("%sconst %s %s;",
-				indent_str, NodeAsSource(&v.Name, context),
+			return fmt.Sprintf("var %s %s;",
+				NodeAsSource(&v.Name, context),
 				NodeAsSource(v.Type, context))
 		}
 	case *CompoundExpression:
 		var ts []string
 		for _, s := range v.Statements.Statements {
-			ts = append(ts, fmt.Sprintf("%s%s", indent_str, NodeAsSource(s, context)))
+			ts = append(ts, NodeAsSource(s, context))
 		}
 		return fmt.Sprintf("{ %s }", strings.Join(ts, " "))
 	case *Assignment: // Assignment is exp not statement
@@ -588,9 +619,12 @@ func NodeAsSource(node Node, context *Context) string {
 		return fmt.Sprintf("print %s;", NodeAsSource(v.Value, context))
 	case *Statements:
 		var ts []string
+		context.startBlock()
 		for _, s := range v.Statements {
-			ts = append(ts, fmt.Sprintf("%s%s", indent_str, NodeAsSource(s, context)))
+			ts = append(ts, context.before(s)...)
+			ts = append(ts, fmt.Sprintf("%s%s%s", indent_str, NodeAsSource(s, context), context.after(s)))
 		}
+		ts = append(ts, context.commentsBefore(context.end)...)
 		return strings.Join(ts, "\n")
 	case *BreakStatement:
 		return "break;"
@@ -599,21 +633,19 @@ func NodeAsSource(node Node, context *Context) string {
 	case *IfStatement:
 		var ts []string
 		ts = append(ts, fmt.Sprintf("if %s {", NodeAsSource(v.Test, context)))
-		ts = append(ts, fmt.Sprintf("%s", NodeAsSource(&v.Consequence, context.NewBlock())))
+		ts = appendBlock(ts, NodeAsSource(&v.Consequence, context.block(context.consequenceEnd(v))))
 
 		if v.Alternative != nil {
 			ts = append(ts, fmt.Sprintf("%s} else {", context.Indent))
-			ts = append(ts, fmt.Sprintf("%s", NodeAsSource(v.Alternative, context.NewBlock())))
-		} else {
-
+			ts = appendBlock(ts, NodeAsSource(v.Alternative, context.block(context.closing(v))))
 		}
 		ts = append(ts, fmt.Sprintf("%s}", context.Indent))
 		return strings.Join(ts, "\n")
 	case *WhileStatement:
 		var ts []string
 		ts = append(ts, fmt.Sprintf("while %s {", NodeAsSource(v.Test, context)))
-		ts = append(ts, NodeAsSource(&v.Body, context.NewBlock()))
-		ts = append(ts, "}")
+		ts = appendBlock(ts, NodeAsSource(&v.Body, context.block(context.closing(v))))
+		ts = append(ts, fmt.Sprintf("%s}", context.Indent))
 		return strings.Join(ts, "\n")
 	case *ReturnStatement:
 		return fmt.Sprintf("return %s;", NodeAsSource(v.Value, context))
@@ -623,9 +655,11 @@ func NodeAsSource(node Node, context *Context) string {
 			ts = append(ts, fmt.Sprintf("%s %s", NodeAsSource(&p.Name, context), NodeAsSource(p.Type, context)))
 		}
 
-		return fmt.Sprintf("func %s(%s) %s{\n%s\n}",
-			NodeAsSource(&v.Name, context), strings.Join(ts, ", "), NodeAsSource(v.ReturnType, context),
-			NodeAsSource(&v.Body, context.NewBlock()))
+		header := fmt.Sprintf("func %s(%s) %s {",
+			NodeAsSource(&v.Name, context), strings.Join(ts, ", "), NodeAsSource(v.ReturnType, context))
+		lines := appendBlock([]string{header}, NodeAsSource(&v.Body, context.block(context.closing(v))))
+		lines = append(lines, fmt.Sprintf("%s}", context.Indent))
+		return strings.Join(lines, "\n")
 	default:
 		panic(fmt.Sprintf("Can't convert %v to source", v))
 	}
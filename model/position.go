@@ -0,0 +1,77 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf8"
+)
+
+// Position is a point in a source file. Line and Column count from 1,
+// Column counts characters (runes), not bytes.
+type Position struct {
+	Offset int // bytes from the start of the source
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Comment is a // or /* */ comment as written, the parser keeps them on the
+// program for the formatter
+type Comment struct {
+	Text string
+	Pos  Position
+	End  Position
+}
+
+// LineTable converts between byte offsets and positions in a source
+type LineTable struct {
+	source string
+	starts []int // offset of the first byte of every line
+}
+
+func NewLineTable(source string) *LineTable {
+	starts := []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &LineTable{source: source, starts: starts}
+}
+
+// Position of offset, offsets out of the source are clamped to it
+func (t *LineTable) Position(offset int) Position {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(t.source) {
+		offset = len(t.source)
+	}
+	line := sort.Search(len(t.starts), func(i int) bool { return t.starts[i] > offset }) - 1
+	column := utf8.RuneCountInString(t.source[t.starts[line]:offset]) + 1
+	return Position{Offset: offset, Line: line + 1, Column: column}
+}
+
+// Offset of line:column, columns past the end of the line give the end of the line
+func (t *LineTable) Offset(line, column int) int {
+	if line < 1 {
+		return 0
+	}
+	if line > len(t.starts) {
+		return len(t.source)
+	}
+	offset := t.starts[line-1]
+	for ; column > 1 && offset < len(t.source) && t.source[offset] != '\n'; column-- {
+		_, size := utf8.DecodeRuneInString(t.source[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// Locator of the bytes start:end
+func (t *LineTable) Locator(file string, start, end int) Locator {
+	return NewPositionLocator(file, t.source, t.Position(start), t.Position(end))
+}
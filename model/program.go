@@ -4,13 +4,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"unicode/utf8"
 )
 
 type Program struct {
-	Source     string
-	Model      Node
-	HaveErrors bool
-	Db         map[int]Locator
+	File        string
+	Source      string
+	Model       Node
+	HaveErrors  bool
+	Diagnostics Diagnostics
+	Comments    []Comment
+	Db          map[int]Locator
+	Types       map[int]string // filled by the checker
+	Host        *Host          // functions of the host it can call, nil for none
+	lines       *LineTable
 }
 
 func NewProgram(source string) *Program {
@@ -18,12 +25,29 @@ func NewProgram(source string) *Program {
 		Source:     source,
 		HaveErrors: false,
 		Db:         make(map[int]Locator),
+		Types:      make(map[int]string),
 	}
 }
 
-func (p *Program) ErrorMessage(message string) {
-	fmt.Println(message)
-	p.HaveErrors = true
+// Report records a diagnostic against the program
+func (p *Program) Report(d Diagnostic) {
+	p.Diagnostics = append(p.Diagnostics, d)
+	if d.Severity == SeverityError {
+		p.HaveErrors = true
+	}
+}
+
+// Diagnostic makes an error pointing at node
+func (p *Program) Diagnostic(node Node, code string, format string, args ...interface{}) Diagnostic {
+	return NewDiagnostic(p.Location(node), code, format, args...)
+}
+
+// Lines gives the offset and position conversions of the source
+func (p *Program) Lines() *LineTable {
+	if p.lines == nil || p.lines.source != p.Source {
+		p.lines = NewLineTable(p.Source)
+	}
+	return p.lines
 }
 
 func ProgramFromFile(filename string) (*Program, error) {
@@ -31,38 +55,83 @@ func ProgramFromFile(filename string) (*Program, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewProgram(string(content)), nil
+	program := NewProgram(string(content))
+	program.File = filename
+	return program, nil
 }
 
-func (p *Program) RecordPosition(node Node, lineno, start, end int) {
+// RecordPosition remembers that node spans the bytes start:end of the source
+func (p *Program) RecordPosition(node Node, start, end int) {
 	// when calling Id it should in Map
-	p.Db[node.Id()] = NewLocator(p.Source, lineno, start, end)
+	p.Db[node.Id()] = p.Lines().Locator(p.File, start, end)
+}
+
+func (p *Program) SetLocation(node Node, loc Locator) {
+	p.Db[node.Id()] = loc
 }
 
 func (p *Program) Location(node Node) Locator {
 	return p.Db[node.Id()]
 }
 
+// RecordType stores the type of an expression. For declarations it is the
+// type of the declared name, for functions the return type.
+func (p *Program) RecordType(node Node, typ string) {
+	p.Types[node.Id()] = typ
+}
+
+func (p *Program) TypeOf(node Node) string {
+	return p.Types[node.Id()]
+}
+
 type Locator struct {
+	File       string
 	SourceCode string
-	Lineno     int
-	Start      int
+	Lineno     int // line of Start
+	Start      int // byte offsets
 	End        int
+	StartPos   Position
+	EndPos     Position
+}
+
+// NewLocator finds the positions of start:end in sourceCode. Use a LineTable
+// when locating many spans of one source.
+func NewLocator(file string, sourceCode string, start, end int) Locator {
+	return NewLineTable(sourceCode).Locator(file, start, end)
 }
 
-func NewLocator(sourceCode string, lineno, start, end int) Locator {
+func NewPositionLocator(file string, sourceCode string, start, end Position) Locator {
 	return Locator{
+		File:       file,
 		SourceCode: sourceCode,
-		Lineno:     lineno,
-		Start:      start,
-		End:        end,
+		Lineno:     start.Line,
+		Start:      start.Offset,
+		End:        end.Offset,
+		StartPos:   start,
+		EndPos:     end,
+	}
+}
+
+// String gives file:line:col-endline:endcol
+func (l Locator) String() string {
+	file := l.File
+	if file == "" {
+		file = "<input>"
 	}
+	return fmt.Sprintf("%s:%s-%s", file, l.StartPos, l.EndPos)
 }
 
 func (l Locator) Source() string {
 	return l.SourceCode[l.Start:l.End]
 }
 
+// Column is the column of Start, in characters from 1
+func (l Locator) Column() int {
+	return l.StartPos.Column
+}
+
+// LineContext gives the line holding start with a ^^^ under start:end.
+// A span running past the end of the line is underlined to the end of the line.
 func (l Locator) LineContext(start, end int) string {
 	if start == 0 {
 		start = l.Start
@@ -70,21 +139,34 @@ func (l Locator) LineContext(start, end int) string {
 	if end == 0 {
 		end = l.End
 	}
-	s := start
-	for s >= 0 && l.SourceCode[s] != '\n' {
-		s--
+	if start > len(l.SourceCode) {
+		start = len(l.SourceCode)
 	}
-	if l.SourceCode[s] == '\n' {
-		s++
+	s := strings.LastIndex(l.SourceCode[:start], "\n") + 1
+	e := strings.IndexByte(l.SourceCode[start:], '\n')
+	if e < 0 {
+		e = len(l.SourceCode)
+	} else {
+		e += start
 	}
-
-	e := end - 1
-	for e < len(l.SourceCode) && l.SourceCode[e] != '\n' {
-		e++
+	if end > e {
+		end = e
 	}
-	if l.SourceCode[e] != '\n' {
-		e++
+	if end <= start {
+		end = start + 1
 	}
-
-	return l.SourceCode[s:e] + "\n" + strings.Repeat(" ", start-s) + strings.Repeat("^", end-start)
+	// keep tabs so the carets line up with the source
+	pad := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, l.SourceCode[s:start])
+	line := strings.TrimRight(l.SourceCode[s:e], "\r")
+	carets := 1
+	if end <= len(l.SourceCode) {
+		carets = utf8.RuneCountInString(l.SourceCode[start:end])
+	}
+	// at the end of a source without a last newline there is one caret past it
+	return line + "\n" + pad + strings.Repeat("^", carets)
 }
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StackFrame is a function running when a program stopped and where it was
+type StackFrame struct {
+	Function string
+	Loc      Locator
+}
+
+// TopLevel names the frame of the statements outside of functions
+const TopLevel = "<program>"
+
+// stackShown is how many frames of a deep stack Render prints at each end
+const stackShown = 10
+
+// RuntimeError stops a running program, Loc is the node that failed
+type RuntimeError struct {
+	Message string
+	Loc     Locator
+	Stack   []StackFrame // innermost first, the first is at Loc
+	Err     error        // the cause when there is one, like a limit of the run
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Loc.StartPos.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s at line %d", e.Message, e.Loc.StartPos.Line)
+}
+
+// Render gives the failing line like a diagnostic, followed by the stack
+//
+//	error: division by zero
+//	 --> test.wb:3:12
+//	  |
+//	3 |     return x / y;
+//	  |            ^^^^^
+//	stack:
+//	  div at test.wb:3:12
+//	  <program> at test.wb:6:7
+func (e *RuntimeError) Render(source string) string {
+	var sb strings.Builder
+	if e.Loc.StartPos.Line == 0 {
+		sb.WriteString(fmt.Sprintf("%s: %s\n", SeverityError, e.Message))
+	} else {
+		if e.Loc.SourceCode != "" {
+			// the failing node may come from another source, in a REPL
+			source = e.Loc.SourceCode
+		}
+		sb.WriteString(NewDiagnostic(e.Loc, "", "%s", e.Message).Render(source))
+	}
+	if len(e.Stack) == 0 {
+		return sb.String()
+	}
+	sb.WriteString("stack:\n")
+	for i, frame := range e.Stack {
+		if len(e.Stack) > 2*stackShown && i == stackShown {
+			sb.WriteString(fmt.Sprintf("  ... %d more\n", len(e.Stack)-2*stackShown))
+		}
+		if len(e.Stack) > 2*stackShown && i >= stackShown && i < len(e.Stack)-stackShown {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("  %s at %s:%d:%d\n", frame.Function, FileName(frame.Loc.File), frame.Loc.StartPos.Line, frame.Loc.StartPos.Column))
+	}
+	return sb.String()
+}
+
+// FileName gives the name of file to show, <input> when it has none
+func FileName(file string) string {
+	if file == "" {
+		return "<input>"
+	}
+	return file
+}
@@ -1,19 +1,24 @@
 package parser
 
 import (
-	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"sort"
 	"strconv"
-	"wabbit-go/common"
+	"strings"
 	"wabbit-go/model"
 	"wabbit-go/tokenize"
 )
 
-func init() {
-	// how to control the log level all the package
-	log.SetLevel(log.DebugLevel)
-}
+// error codes of the parser
+const (
+	ErrExpected   = "E0101"
+	ErrUnexpected = "E0102"
+	ErrLiteral    = "E0103"
+)
+
+// syntaxError unwinds the parser after a failed expectation, the diagnostic is already recorded
+type syntaxError struct{}
 
 type EOF struct {
 	Type   string
@@ -26,32 +31,26 @@ type EOF struct {
 
 type TokenStream struct {
 	program   *model.Program
-	gen       chan tokenize.Token
+	lexer     *tokenize.Lexer
 	lookahead tokenize.Token
 	//current    tokenize.Token // save
-	lastIndex  int
-	haveErrors bool
+	last        model.Position // end of the last accepted token
+	diagnostics model.Diagnostics
 }
 
-func NewTokenStream(program *model.Program) (*TokenStream, error) {
-	tokens, err := tokenize.Tokenize(program.Source)
-
-	if err != nil {
-		log.Errorf("tokenize err %v", err)
-		return nil, err
-	}
+// NewTokenStream pulls tokens of the program from a lexer as the parser goes.
+// Tokenizer errors end up with the syntax errors and the bad characters are
+// left out, so parsing still goes on.
+func NewTokenStream(program *model.Program) *TokenStream {
 	ts := &TokenStream{
-		program:    program,
-		gen:        common.SliceToChannel(tokens),
-		haveErrors: false,
+		program: program,
+		lexer:   tokenize.NewStringLexer(program.File, program.Source),
 	}
-	ts.lookahead = <-ts.gen
-	log.Debugf("beginning %v", ts.lookahead)
-	return ts, nil
+	ts.lookahead = ts.lexer.Next()
+	return ts
 }
 
 func (ts *TokenStream) Peek(types ...string) *tokenize.Token {
-	log.Debugf("Peeking %v while ts.lookahead.Type is %v ", types, ts.lookahead.Type)
 	for _, t := range types {
 		if ts.lookahead.Type == t {
 			current := ts.lookahead
@@ -64,27 +63,74 @@ func (ts *TokenStream) Peek(types ...string) *tokenize.Token {
 // the lookhead alreay change we can't just using the point
 func (ts *TokenStream) Accept(types ...string) *tokenize.Token {
 	tok := ts.Peek(types...)
-	log.Debugf("Accpet.... %v while head is %v", types, tok)
 	if tok != nil {
-		ts.lastIndex = ts.lookahead.Index + len(ts.lookahead.Value)
-		ts.lookahead = <-ts.gen // this while overwrite it
-		log.Debugf("ts.lookahead is %v", ts.lookahead)
+		ts.last = ts.lookahead.End
+		ts.lookahead = ts.lexer.Next()
 	}
 	return tok
 }
 
-func (ts *TokenStream) Expect(types ...string) (*tokenize.Token, error) {
+// Expect accepts one of types or fails the parse with a diagnostic
+func (ts *TokenStream) Expect(types ...string) *tokenize.Token {
 	tok := ts.Accept(types...)
 	if tok == nil {
-		return nil, errors.New(fmt.Sprintf("Syntax error at %s expect %v but got %v pos %v",
-			ts.lookahead.Value, types, tok, ts.lastIndex))
+		var expected []string
+		for _, t := range types {
+			expected = append(expected, tokenize.Describe(t))
+		}
+		ts.Fail(ErrExpected, "expected %s, found %s", strings.Join(expected, " or "), found(ts.lookahead))
 	}
-	return tok, nil
+	return tok
 }
 
-func (ts *TokenStream) Synchronize(types ...string) {
-	for ts.Accept(types...) == nil {
-		ts.lookahead = <-ts.gen
+// Report records a diagnostic without stopping the parse
+func (ts *TokenStream) Report(d model.Diagnostic) {
+	ts.diagnostics = append(ts.diagnostics, d)
+}
+
+// Fail records an error at the lookahead token and unwinds to the statement being parsed
+func (ts *TokenStream) Fail(code string, format string, args ...interface{}) {
+	ts.Report(ts.Error(code, format, args...))
+	panic(syntaxError{})
+}
+
+// Error makes a diagnostic pointing at the lookahead token
+func (ts *TokenStream) Error(code string, format string, args ...interface{}) model.Diagnostic {
+	return ts.TokenError(ts.lookahead, code, format, args...)
+}
+
+// TokenError makes a diagnostic pointing at tok
+func (ts *TokenStream) TokenError(tok tokenize.Token, code string, format string, args ...interface{}) model.Diagnostic {
+	return model.NewDiagnostic(tok.Locator(ts.program.Source), code, format, args...)
+}
+
+func found(tok tokenize.Token) string {
+	if tok.Type == "EOF" {
+		return tokenize.Describe(tok.Type)
+	}
+	return fmt.Sprintf("'%s'", tok.Value)
+}
+
+// Synchronize skips the rest of a broken statement: up to and including the
+// next ';' or the '}' closing a block opened inside it. A '}' closing the
+// enclosing block and EOF are left for the caller.
+func (ts *TokenStream) Synchronize() {
+	depth := 0
+	for ts.Peek("EOF") == nil {
+		if ts.Accept("LBRACE") != nil {
+			depth++
+		} else if ts.Peek("RBRACE") != nil {
+			if depth == 0 {
+				return
+			}
+			ts.Accept("RBRACE")
+			depth--
+			if depth == 0 && ts.Peek("SEMI", "ELSE") == nil {
+				return
+			}
+		} else if tok := ts.Accept(ts.lookahead.Type); tok.Type == "SEMI" && depth == 0 {
+			return
+		}
 	}
 }
 
@@ -93,44 +139,49 @@ func (ts *TokenStream) Synchronize(types ...string) {
 type constructFunc func(model.Node) model.Node
 
 func (ts *TokenStream) Builder() func(func(constructFunc) model.Node) model.Node {
-	startLine := ts.lookahead.Lineno
-	startIndex := ts.lookahead.Index
+	start := ts.lookahead.Pos
 
 	construct := func(node model.Node) model.Node {
 		//print("nodetype=", nodetype)
 		//node := nodeInit(args)
 		// we got node don't init any more
-		ts.program.RecordPosition(node, startLine, startIndex, ts.lastIndex) // ts.lookahead.index
+		ts.program.SetLocation(node, model.NewPositionLocator(ts.program.File, ts.program.Source, start, ts.last))
 		return node
 	}
 
 	return func(do func(constructFunc) model.Node) model.Node {
-		//defer func() {
-		//	if err := recover(); err != nil {
-		//		if err, ok := err.(SyntaxError); ok {
-		//			fmt.Printf("%d: %v", startLine, err)
-		//			ts.haveErrors = true
-		//		} else {
-		//			panic(err)
-		//		}
-		//	}
-		//}()
 		return do(construct)
 	}
 }
 
+// ParseProgram builds the AST of program. All tokenizer and syntax errors
+// come back together as model.Diagnostics and are reported to the program.
+// Broken statements are left out, program.Model still holds the rest.
 func ParseProgram(program *model.Program) error {
 	// Code this to recognize any Wabbit program and build AST
-	ts, err := NewTokenStream(program)
-	if err != nil {
-		return err
-	}
+	ts := NewTokenStream(program)
 	statements := parseStatements(ts)
-	_, err = ts.Expect("EOF")
-	if err != nil {
-		return err
+	for ts.Peek("EOF") == nil {
+		// a stray '}', the only thing parseStatements stops at
+		ts.Report(ts.Error(ErrUnexpected, "unexpected %s", found(ts.lookahead)))
+		ts.Accept(ts.lookahead.Type)
+		more := parseStatements(ts)
+		statements.Statements = append(statements.Statements, more.Statements...)
 	}
 	program.Model = statements
+	program.Comments = ts.lexer.Comments()
+
+	// tokenizer errors were found along the way, put everything in source order
+	ts.diagnostics = append(ts.diagnostics, ts.lexer.Diagnostics()...)
+	sort.SliceStable(ts.diagnostics, func(i, j int) bool {
+		return ts.diagnostics[i].Span.Start < ts.diagnostics[j].Span.Start
+	})
+	for _, d := range ts.diagnostics {
+		program.Report(d)
+	}
+	if len(ts.diagnostics) > 0 {
+		return ts.diagnostics
+	}
 	return nil
 }
 
@@ -145,14 +196,29 @@ func parseStatements(ts *TokenStream) *model.Statements {
 		for ts.Peek("RBRACE", "EOF") == nil {
 			// python not None mean none is None
 			log.Debugf("before parseStatement")
-			statement := parseStatement(ts) //
-			statements = append(statements, statement)
+			if statement := parseStatementOrSync(ts); statement != nil {
+				statements = append(statements, statement)
+			}
 		}
-		return new(&model.Statements{statements})
+		return new(&model.Statements{Statements: statements})
 	})
 	return node.(*model.Statements)
 }
 
+// parseStatementOrSync gives nil for a broken statement after skipping past it
+func parseStatementOrSync(ts *TokenStream) (statement model.Statement) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(syntaxError); !ok {
+				panic(r)
+			}
+			ts.Synchronize()
+			statement = nil
+		}
+	}()
+	return parseStatement(ts)
+}
+
 func parseStatement(ts *TokenStream) model.Statement {
 	// Parse different types of statements
 	if ts.Peek("PRINT") != nil {
@@ -188,7 +254,7 @@ func parsePrintStmt(ts *TokenStream) model.Statement {
 		expr := parseExpression(ts)
 		log.Debugf("parsePrintStmt expr is %v", expr)
 		ts.Expect("SEMI")
-		return new(&model.PrintStatement{expr}) // TODO need to detect Do we need &
+		return new(&model.PrintStatement{Value: expr}) // TODO need to detect Do we need &
 	})
 	return node.(model.Statement)
 }
@@ -199,19 +265,15 @@ func parseConstDecl(ts *TokenStream) model.Statement {
 
 	node := builder(func(new constructFunc) model.Node {
 		ts.Expect("CONST")
-		nameTk, err := ts.Expect("ID")
-		if err != nil {
-
-		}
-		name := nameTk.Value
+		name := ts.Expect("ID").Value
 		var typ model.Type
 		if tok := ts.Accept("ID"); tok != nil {
-			typ = &model.NameType{tok.Value}
+			typ = &model.NameType{Name: tok.Value}
 		}
 		ts.Expect("ASSIGN")
 		value := parseExpression(ts)
 		ts.Expect("SEMI")
-		return new(&model.ConstDeclaration{model.Name{name}, typ, value})
+		return new(&model.ConstDeclaration{Name: model.Name{Text: name}, Type: typ, Value: value})
 	})
 
 	return node.(model.Statement)
@@ -222,20 +284,17 @@ func parseVarDecl(ts *TokenStream) model.Statement {
 	builder := ts.Builder()
 	node := builder(func(new constructFunc) model.Node {
 		ts.Expect("VAR")
-		name, err := ts.Expect("ID")
-		if err != nil {
-			log.Debugf("err parseVarDecl")
-		}
+		name := ts.Expect("ID")
 		var typ model.Type
 		if tok := ts.Accept("ID"); tok != nil {
-			typ = &model.NameType{tok.Value}
+			typ = &model.NameType{Name: tok.Value}
 		}
 		var value model.Expression
 		if tok := ts.Accept("ASSIGN"); tok != nil {
 			value = parseExpression(ts)
 		}
 		ts.Expect("SEMI")
-		return new(&model.VarDeclaration{model.Name{name.Value}, typ, value})
+		return new(&model.VarDeclaration{Name: model.Name{Text: name.Value}, Type: typ, Value: value})
 	})
 	return node.(model.Statement)
 }
@@ -245,7 +304,7 @@ func parseExprStmt(ts *TokenStream) model.Statement {
 	node := builder(func(new constructFunc) model.Node {
 		expr := parseExpression(ts)
 		ts.Expect("SEMI")
-		return new(&model.ExpressionAsStatement{expr})
+		return new(&model.ExpressionAsStatement{Expression: expr})
 	})
 	return node.(model.Statement)
 }
@@ -266,7 +325,7 @@ func parseIfStmt(ts *TokenStream) model.Statement {
 			ts.Expect("RBRACE")
 		}
 		// how strange the same struct using different type
-		return new(&model.IfStatement{test, *consequence, alternative})
+		return new(&model.IfStatement{Test: test, Consequence: *consequence, Alternative: alternative})
 	})
 	return node.(model.Statement)
 
@@ -283,7 +342,7 @@ func parseWhileStmt(ts *TokenStream) model.Statement {
 		body := parseStatements(ts)
 		ts.Expect("RBRACE")
 		// how strange the same struct using different type
-		return new(&model.WhileStatement{test, *body})
+		return new(&model.WhileStatement{Test: test, Body: *body})
 	})
 	return node.(model.Statement)
 }
@@ -318,7 +377,7 @@ func parseReturnStmt(ts *TokenStream) model.Statement {
 		ts.Expect("RETURN")
 		value := parseExpression(ts)
 		ts.Expect("SEMI")
-		return new(&model.ReturnStatement{value})
+		return new(&model.ReturnStatement{Value: value})
 	})
 	return node.(model.Statement)
 }
@@ -329,28 +388,16 @@ func parseFuncDecl(ts *TokenStream) model.Statement {
 	node := builder(func(new constructFunc) model.Node {
 
 		ts.Expect("FUNC")
-		nameToken, err := ts.Expect("ID")
-		if err != nil {
-			log.Debugf("parseFuncDecl %v", err)
-		}
-		name := model.Name{nameToken.Value}
+		name := model.Name{Text: ts.Expect("ID").Value}
 		ts.Expect("LPAREN")
 		var params []model.Parameter
 		for ts.Peek("RPAREN") == nil {
 
 			paramsBuilder := ts.Builder()
 			node := paramsBuilder(func(newp constructFunc) model.Node {
-				pnameToken, err := ts.Expect("ID")
-				if err != nil {
-					log.Debugf("wrong %v", err)
-				}
-				pname := model.Name{pnameToken.Value}
-				ptypeNode, err := ts.Expect("ID")
-				if err != nil {
-					log.Debugf("wrong %v", err)
-				}
-				ptype := model.NameType{ptypeNode.Value}
-				return newp(&model.Parameter{pname, &ptype})
+				pname := model.Name{Text: ts.Expect("ID").Value}
+				ptype := model.NameType{Name: ts.Expect("ID").Value}
+				return newp(&model.Parameter{Name: pname, Type: &ptype})
 			})
 			param := node.(*model.Parameter)
 			params = append(params, *param)
@@ -360,15 +407,11 @@ func parseFuncDecl(ts *TokenStream) model.Statement {
 			}
 		}
 		ts.Expect("RPAREN")
-		retTypeNode, err := ts.Expect("ID")
-		if err != nil {
-			log.Debugf("")
-		}
-		retType := model.NameType{retTypeNode.Value}
+		retType := model.NameType{Name: ts.Expect("ID").Value}
 		ts.Expect("LBRACE")
 		body := parseStatements(ts)
 		ts.Expect("RBRACE")
-		return new(&model.FunctionDeclaration{name, params, &retType, *body})
+		return new(&model.FunctionDeclaration{Name: name, Parameters: params, ReturnType: &retType, Body: *body})
 	})
 	return node.(model.Statement)
 }
@@ -384,7 +427,7 @@ func parseAssignExpr(ts *TokenStream) model.Expression {
 		left := parseOrExpr(ts)
 		for ts.Accept("ASSIGN") != nil {
 			right := parseAssignExpr(ts)
-			left = new(&model.Assignment{left, right}).(model.Expression)
+			left = new(&model.Assignment{Location: left, Value: right}).(model.Expression)
 		}
 		return left
 	})
@@ -399,7 +442,7 @@ func parseOrExpr(ts *TokenStream) model.Expression {
 		left := parseAndExpr(ts)
 		for ts.Accept("LOR") != nil {
 			right := parseAndExpr(ts)
-			left = new(&model.LogOr{left, right}).(model.Expression)
+			left = new(&model.LogOr{Left: left, Right: right}).(model.Expression)
 		}
 		return left
 	})
@@ -414,7 +457,7 @@ func parseAndExpr(ts *TokenStream) model.Expression {
 		left := parseRelExpr(ts)
 		for ts.Accept("LAND") != nil {
 			right := parseRelExpr(ts)
-			left = new(&model.LogAnd{left, right}).(model.Expression)
+			left = new(&model.LogAnd{Left: left, Right: right}).(model.Expression)
 		}
 		return left
 	})
@@ -436,17 +479,17 @@ func parseRelExpr(ts *TokenStream) model.Expression {
 			op := tok.Value
 			right := parseAddExpr(ts)
 			if op == "<" {
-				left = new(&model.Lt{left, right}).(model.Expression)
+				left = new(&model.Lt{Left: left, Right: right}).(model.Expression)
 			} else if op == "<=" {
-				left = new(&model.Le{left, right}).(model.Expression)
+				left = new(&model.Le{Left: left, Right: right}).(model.Expression)
 			} else if op == ">" {
-				left = new(&model.Gt{left, right}).(model.Expression)
+				left = new(&model.Gt{Left: left, Right: right}).(model.Expression)
 			} else if op == ">=" {
-				left = new(&model.Ge{left, right}).(model.Expression)
+				left = new(&model.Ge{Left: left, Right: right}).(model.Expression)
 			} else if op == "==" {
-				left = new(&model.Eq{left, right}).(model.Expression)
+				left = new(&model.Eq{Left: left, Right: right}).(model.Expression)
 			} else if op == "!=" {
-				left = new(&model.Ne{left, right}).(model.Expression)
+				left = new(&model.Ne{Left: left, Right: right}).(model.Expression)
 			}
 		}
 
@@ -470,9 +513,9 @@ func parseAddExpr(ts *TokenStream) model.Expression {
 			op := tok.Value
 			right := parseMulExpr(ts)
 			if op == "+" {
-				left = new(&model.Add{left, right}).(model.Expression)
+				left = new(&model.Add{Left: left, Right: right}).(model.Expression)
 			} else if op == "-" {
-				left = new(&model.Sub{left, right}).(model.Expression)
+				left = new(&model.Sub{Left: left, Right: right}).(model.Expression)
 			}
 		}
 
@@ -496,9 +539,9 @@ func parseMulExpr(ts *TokenStream) model.Expression {
 			op := tok.Value
 			right := parseFactor(ts)
 			if op == "*" {
-				left = new(&model.Mul{left, right}).(model.Expression)
+				left = new(&model.Mul{Left: left, Right: right}).(model.Expression)
 			} else if op == "/" {
-				left = new(&model.Div{left, right}).(model.Expression)
+				left = new(&model.Div{Left: left, Right: right}).(model.Expression)
 			}
 		}
 
@@ -515,42 +558,38 @@ func parseFactor(ts *TokenStream) model.Expression {
 		if tok := ts.Accept("INTEGER"); tok != nil {
 			num, err := strconv.Atoi(tok.Value)
 			if err != nil {
-				log.Errorf("wrong int %v ", tok.Value)
-				return nil
+				ts.Report(ts.TokenError(*tok, ErrLiteral, "integer literal %s out of range", tok.Value))
 			}
-			return new(&model.Integer{num})
+			return new(&model.Integer{Value: num})
 		} else if tok := ts.Accept("FLOAT"); tok != nil {
 			num, err := strconv.ParseFloat(tok.Value, 64)
 			if err != nil {
-				log.Errorf("wrong float %v", tok.Value)
-				return nil
+				ts.Report(ts.TokenError(*tok, ErrLiteral, "float literal %s out of range", tok.Value))
 			}
-			return new(&model.Float{num})
+			return new(&model.Float{Value: num})
 		} else if tok := ts.Accept("TRUE", "FALSE"); tok != nil {
-			return new(&model.NameBool{tok.Value})
+			return new(&model.NameBool{Name: tok.Value})
 		} else if tok := ts.Accept("CHAR"); tok != nil {
-			return new(&model.Character{tok.Value})
+			return new(&model.Character{Value: tok.Value})
 		} else if tok := ts.Accept("LPAREN"); tok != nil {
 			log.Debugf("factor LPAREN")
 			expr := parseExpression(ts)
 			ts.Expect("RPAREN")
 			log.Debugf("factor RPAREN")
-			return new(&model.Grouping{expr})
+			return new(&model.Grouping{Expression: expr})
 		} else if tok := ts.Accept("LBRACE"); tok != nil {
 			stmts := parseStatements(ts)
 			ts.Expect("RBRACE")
-			return new(&model.CompoundExpression{*stmts})
+			return new(&model.CompoundExpression{Statements: *stmts})
 		} else if tok := ts.Accept("PLUS", "MINUS", "LNOT"); tok != nil {
 			log.Debugf("token 0 %v ", tok)
 			operand := parseFactor(ts)
 			if tok.Value == "+" {
-				return new(&model.Pos{operand})
+				return new(&model.Pos{Operand: operand})
 			} else if tok.Value == "-" {
-				return new(&model.Neg{operand})
-			} else if tok.Value == "!" {
-				return new(&model.Not{operand})
+				return new(&model.Neg{Operand: operand})
 			} else {
-				panic(fmt.Sprintf("Unexpected token 1 %v", tok))
+				return new(&model.Not{Operand: operand})
 			}
 		} else if tok := ts.Accept("ID"); tok != nil {
 			// Either a variable or function call
@@ -558,14 +597,14 @@ func parseFactor(ts *TokenStream) model.Expression {
 			if ts.Accept("LPAREN") != nil {
 				args := parseArguments(ts)
 				ts.Expect("RPAREN")
-				return new(&model.FunctionApplication{&model.Name{tok.Value}, args})
+				return new(&model.FunctionApplication{Func: &model.Name{Text: tok.Value}, Arguments: args})
 			} else {
-				return new(&model.Name{tok.Value})
+				return new(&model.Name{Text: tok.Value})
 			}
 		} else {
-			panic(fmt.Sprintf("Unexpected token %v", ts.lookahead))
+			ts.Fail(ErrUnexpected, "expected an expression, found %s", found(ts.lookahead))
+			return nil
 		}
-		return nil
 	})
 	return node.(model.Expression)
 	//panic(fmt.Sprintf("Unexpected token %v", ts.Lookahead))
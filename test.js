@@ -13,11 +13,21 @@
 const fs = require ('fs');
 const bytes = fs.readFileSync (__dirname + '/out.wasm');
 
+const goFloat = (x) => {
+    // print floats the way Go's fmt.Println does, like the other backends
+    const [m, e] = x.toExponential().split('e');
+    const exp = Number(e);
+    if (exp < -4 || exp >= 6) {
+        return m + 'e' + (exp < 0 ? '-' : '+') + String(Math.abs(exp)).padStart(2, '0');
+    }
+    return String(x);
+};
+
 let importObject = {
     // Runtime functions imported by Wabbit from the JavaScript environment. 
     env: {
         _printi: (x) => { console.log(x); },
-        _printf: (x) => { console.log(x); },
+        _printf: (x) => { console.log(goFloat(x)); },
         _printb: (x) => { console.log(x===1); },
         _printc: (x) => { process.stdout.write(String.fromCharCode(x)); },
       },
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"wabbit-go/check"
+	"wabbit-go/common"
+	"wabbit-go/driver"
+	"wabbit-go/interpreter"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+	"wabbit-go/wvm"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected outputs in Output/ from the interpreter")
+
+var outputPath = "Output"
+
+// run gives what a backend running in process prints, failing t when
+// the backend fails
+func run(t testing.TB, backend func(*model.Program, common.RunOptions) error, program *model.Program) string {
+	var stdout bytes.Buffer
+	if err := backend(program, common.RunOptions{Stdout: &stdout}); err != nil {
+		t.Errorf("%s: %v", program.File, err)
+	}
+	return stdout.String()
+}
+
+func interpret(program *model.Program, options common.RunOptions) error {
+	return interpreter.InterpretProgram(program, options)
+}
+
+func runClosures(program *model.Program, options common.RunOptions) error {
+	return interpreter.CompileProgram(program, options)
+}
+
+func runWvm(program *model.Program, options common.RunOptions) error {
+	return wvm.Wvm(program, options)
+}
+
+func loadProgram(t testing.TB, file string) *model.Program {
+	program, err := parser.HandleFile(file)
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	if diagnostics := check.CheckProgram(program); len(diagnostics) > 0 {
+		t.Fatalf("%s: %v", file, model.Diagnostics(diagnostics))
+	}
+	return program
+}
+
+// compiled runs file with a backend that needs external tools, through the driver
+func compiled(t *testing.T, file string, backend string, tools ...string) string {
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	args := []string{"run", "--backend=" + backend, "--runtime=" + filepath.Join("..", "llvm", "runtime", "runtime.c"), file}
+	if status := driver.Main(args, &stdout, &stderr); status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr.String())
+	}
+	return stdout.String()
+}
+
+// every backend prints the expected output of every program
+func TestBackendsAgree(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(rightProgramPath, "*.wb"))
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".wb")
+		t.Run(name, func(t *testing.T) {
+			program := loadProgram(t, file)
+			interp := run(t, interpret, program)
+
+			expected := filepath.Join(outputPath, name+".out")
+			if *update {
+				if err := os.WriteFile(expected, []byte(interp), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			content, err := os.ReadFile(expected)
+			if err != nil {
+				t.Fatalf("no expected output, run go test -update: %v", err)
+			}
+			want := string(content)
+
+			backends := []struct {
+				name string
+				run  func(t *testing.T) string
+			}{
+				{"interp", func(t *testing.T) string { return interp }},
+				{"closure", func(t *testing.T) string { return run(t, runClosures, program) }},
+				{"wvm", func(t *testing.T) string { return run(t, runWvm, program) }},
+				{"llvm", func(t *testing.T) string { return compiled(t, file, "llvm", "clang") }},
+				{"wasm", func(t *testing.T) string { return compiled(t, file, "wasm", "node", "wat2wasm") }},
+			}
+			for _, backend := range backends {
+				backend := backend
+				t.Run(backend.name, func(t *testing.T) {
+					if got := backend.run(t); got != want {
+						t.Errorf("output differs from %s:\n%s", expected, common.Diff(expected, backend.name, want, got))
+					}
+				})
+			}
+		})
+	}
+}
+
+// programs running at the same time don't see each other's output
+func TestConcurrentRuns(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(rightProgramPath, "*.wb"))
+	var wg sync.WaitGroup
+	for _, file := range files {
+		if strings.Contains(file, "mandel") {
+			// slow, and proves nothing more
+			continue
+		}
+		for _, backend := range []func(*model.Program, common.RunOptions) error{interpret, runClosures, runWvm} {
+			file, backend := file, backend
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				program, err := parser.HandleFile(file)
+				if err != nil {
+					t.Errorf("%s: %v", file, err)
+					return
+				}
+				if diagnostics := check.CheckProgram(program); len(diagnostics) > 0 {
+					t.Errorf("%s: %v", file, model.Diagnostics(diagnostics))
+					return
+				}
+				want, err := os.ReadFile(filepath.Join(outputPath, strings.TrimSuffix(filepath.Base(file), ".wb")+".out"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got := run(t, backend, program); got != string(want) {
+					t.Errorf("%s: output differs:\n%s", file, common.Diff("want", "got", string(want), got))
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
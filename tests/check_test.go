@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"wabbit-go/check"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+)
+
+func TestCheckRightProgram(t *testing.T) {
+	rightFiles, _ := filepath.Glob(rightProgramPath + "/*.wb")
+	for _, rightFile := range rightFiles {
+		p, err := parser.HandleFile(rightFile)
+		if err != nil {
+			t.Fatalf("%s: %v", rightFile, err)
+		}
+		for _, d := range check.CheckProgram(p) {
+			t.Errorf("%s: unexpected %v", filepath.Base(rightFile), d)
+		}
+	}
+}
+
+func TestCheckErrorProgram(t *testing.T) {
+	// every line annotated with an error in tests/Error
+	expected := map[string][]int{
+		"19_error_script.wb": {5, 6, 8, 9, 11, 14, 15, 18, 21, 29, 34, 35, 37, 40, 41},
+		"25_error_func.wb":   {11, 12, 15, 16, 19, 25, 28, 32, 39, 44, 53, 58, 59},
+	}
+	for name, lines := range expected {
+		p, err := parser.HandleFile(filepath.Join(errorProgramPath, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		diagnostics := check.CheckProgram(p)
+		reported := map[int]bool{}
+		for _, d := range diagnostics {
+			t.Logf("%s:%v", name, d)
+			reported[d.Line] = true
+		}
+		for _, line := range lines {
+			if !reported[line] {
+				t.Errorf("%s: no error reported on line %d", name, line)
+			}
+		}
+	}
+}
+
+// break, continue and return can't leave a compound expression, loops
+// inside it can still be left
+func TestCheckCompoundControlFlow(t *testing.T) {
+	for source, want := range map[string]string{
+		"while true { print 1 + { break; 5; }; }":                                  "break out of a compound expression",
+		"while true { var x = { continue; 5; }; }":                                 "continue out of a compound expression",
+		"func f(x int) int { return x + { return 7; 1; }; }":                       "return out of a compound expression",
+		"func f(x int) int { var y = { while true { return 1; } 2; }; return y; }": "return out of a compound expression",
+		"var x = { break; 1; };":                                                   "break out of a compound expression",
+		"var x = { var i = 0; while true { break; } i; };":                         "",
+		"func f(x int) int { var y = { var i = x; while i > 0 { if i == 2 { break; } i = i - 1; continue; } i; }; return y; }": "",
+	} {
+		program := model.NewProgram(source)
+		if err := parser.ParseProgram(program); err != nil {
+			t.Fatalf("%s: %v", source, err)
+		}
+		var got []string
+		for _, d := range check.CheckProgram(program) {
+			if d.Code != check.ErrControlFlow {
+				t.Errorf("%s: unexpected %v", source, d)
+			}
+			got = append(got, d.Message)
+		}
+		if strings.Join(got, "\n") != want {
+			t.Errorf("%s: got %q, want %q", source, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"wabbit-go/common"
+	"wabbit-go/driver"
+	"wabbit-go/interpreter"
+)
+
+const debugSource = `var total = 0;
+func add(a int, b int) int {
+    var sum = a + b;
+    return sum;
+}
+var i = 0;
+while i < 3 {
+    var x = i * 2;
+    total = add(total, x);
+    i = i + 1;
+}
+print total;
+`
+
+// debug runs debugSource under the debugger with commands, giving what
+// the debugger and the program printed
+func debug(t *testing.T, commands string, breakpoints ...int) string {
+	program := checkSource(t, debugSource)
+	program.File = "debug.wb"
+	var out strings.Builder
+	d := driver.NewDebugger(program, strings.NewReader(commands), &out)
+	for _, line := range breakpoints {
+		if err := d.Break(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := interpreter.NewContext(context.Background(), program, common.RunOptions{Stdout: &out})
+	c.SetDebugger(d)
+	c.Run(program)
+	return out.String()
+}
+
+func TestDebugStepping(t *testing.T) {
+	got := debug(t, "s\ns\ns\nn\nn\ns\ns\ns\nbt\nfinish\np\nq\n")
+	want := `debug.wb:1: var total = 0;
+debug.wb:6: var i = 0;
+debug.wb:7: while i < 3 {
+debug.wb:8: var x = i * 2;
+debug.wb:9: total = add(total, x);
+debug.wb:10: i = i + 1;
+debug.wb:8: var x = i * 2;
+debug.wb:9: total = add(total, x);
+debug.wb:3: var sum = a + b;
+#0 add at line 3
+#1 <program> at line 9
+debug.wb:10: i = i + 1;
+x int = 2
+i int = 1
+total int = 2
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDebugBreakpoints(t *testing.T) {
+	// an empty line repeats p x, the breakpoint is gone before the second call
+	got := debug(t, "c\np b\np x\n\nd 4\nb 12\nc\nl\nc\n", 4)
+	want := `debug.wb:1: var total = 0;
+debug.wb:4: return sum;
+b int = 0
+no variable 'x' here
+no variable 'x' here
+debug.wb:12: print total;
+     9 |     total = add(total, x);
+    10 |     i = i + 1;
+    11 | }
+=>  12 | print total;
+    13 | 
+6
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"wabbit-go/model"
+	"wabbit-go/tokenize"
+)
+
+func TestRenderDiagnostic(t *testing.T) {
+	source := "var x int = 1;\nprint y;\n"
+	loc := model.NewLocator("test.wb", source, 21, 22)
+	d := model.NewDiagnostic(loc, "E0201", "undefined name '%s'", "y")
+	d.Notes = append(d.Notes, "names must be declared before use")
+
+	if d.Column != 7 {
+		t.Errorf("column is %d, want 7", d.Column)
+	}
+	if got, want := d.Error(), "test.wb:2:7: error[E0201]: undefined name 'y'"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want := "error[E0201]: undefined name 'y'\n" +
+		" --> test.wb:2:7\n" +
+		"  |\n" +
+		"2 | print y;\n" +
+		"  |       ^\n" +
+		"  = note: names must be declared before use\n"
+	if got := d.Render(source); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTokenizeDiagnostics(t *testing.T) {
+	filename := filepath.Join(wrongProgramPath, "error_lex.wb")
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tokenize.TokenizeFile(filename, string(source))
+	diagnostics, ok := err.(model.Diagnostics)
+	if !ok {
+		t.Fatalf("expected diagnostics, got %v", err)
+	}
+	expected := []struct {
+		code string
+		line int
+	}{
+		{tokenize.ErrUnterminatedChar, 1},
+		{tokenize.ErrUnterminatedComment, 8},
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("got %d diagnostics, want %d:\n%v", len(diagnostics), len(expected), diagnostics)
+	}
+	for i, e := range expected {
+		if diagnostics[i].Code != e.code || diagnostics[i].Line != e.line || diagnostics[i].File != filename {
+			t.Errorf("got %v, want %s on line %d", diagnostics[i], e.code, e.line)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wabbit-go/driver"
+)
+
+func wabbit(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	status := driver.Main(args, &stdout, &stderr)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestDriverCommands(t *testing.T) {
+	file := filepath.Join(rightProgramPath, "01_intbinop.wb")
+	tail := filepath.Join(rightProgramPath, "25_tailrecurisve.wb")
+	cases := []struct {
+		args   []string
+		status int
+		stdout string // a line the output must have
+	}{
+		{[]string{"tokens", file}, 0, "Token(PRINT, print, 5:1-5:6)"},
+		{[]string{"ast", file}, 0, "    Value: Add @5:7-5:12"},
+		{[]string{"check", file}, 0, ""},
+		{[]string{"fmt", file}, 0, "print 2 + 3;"},
+		{[]string{"check", filepath.Join("Error", "19_error_script.wb")}, 1, ""},
+		{[]string{"run", "--backend=nope", file}, 1, ""},
+		{[]string{"run", "--engine=closure", file}, 0, "5\n"},
+		{[]string{"run", "--engine=nope", file}, 1, ""},
+		{[]string{"run", "--max-depth=2", tail}, 0, "4501500"},
+		{[]string{"run", "--max-depth=1", tail}, 1, ""},
+		{[]string{"run", "-b", "wvm", "--max-steps=10", file}, 1, ""},
+		{[]string{"run", "--max-output=2", file}, 1, "5\n"},
+		{[]string{"nope", file}, 2, ""},
+		{[]string{"check"}, 1, ""},
+		{[]string{"check", "--log-level=loud", file}, 2, ""},
+		{[]string{"run", "--emit=exe", file}, 2, ""},
+	}
+	for _, c := range cases {
+		status, stdout, _ := wabbit(c.args...)
+		if status != c.status {
+			t.Errorf("wabbit %v: exit status %d, want %d", c.args, status, c.status)
+		}
+		if !strings.Contains(stdout, c.stdout) {
+			t.Errorf("wabbit %v: output has no %q:\n%s", c.args, c.stdout, stdout)
+		}
+	}
+}
+
+func TestDriverOutputAndEmit(t *testing.T) {
+	dir := t.TempDir()
+	source, err := os.ReadFile(filepath.Join(rightProgramPath, "01_intbinop.wb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "prog.wb")
+	if err := os.WriteFile(file, source, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "prog.txt")
+	if status, stdout, stderr := wabbit("tokens", "-o", out, file); status != 0 || stdout != "" {
+		t.Fatalf("exit status %d, stdout %q, stderr %q", status, stdout, stderr)
+	}
+	if tokens, err := os.ReadFile(out); err != nil || !strings.HasPrefix(string(tokens), "Token(PRINT") {
+		t.Errorf("-o wrote %q, %v", tokens, err)
+	}
+	if status, _, stderr := wabbit("check", "--emit=tokens,ast", file); status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	for _, name := range []string{"prog.tokens", "prog.ast"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s not emitted: %v", name, err)
+		}
+	}
+}
+
+func TestDriverRuntimeError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "div.wb")
+	if err := os.WriteFile(file, []byte("var x = 0;\nprint 1;\nprint 1 / x;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range []string{"interp", "wvm"} {
+		status, stdout, stderr := wabbit("run", "-b", backend, file)
+		if status != 1 || stdout != "1\n" {
+			t.Errorf("%s: exit status %d, output %q", backend, status, stdout)
+		}
+		if want := "error: division by zero\n --> " + file + ":3:7\n"; !strings.HasPrefix(stderr, want) || !strings.Contains(stderr, "stack:\n  <program> at") {
+			t.Errorf("%s: stderr is\n%s", backend, stderr)
+		}
+	}
+}
+
+func TestDriverBuildExec(t *testing.T) {
+	object := filepath.Join(t.TempDir(), "fib.wbc")
+	if status, _, stderr := wabbit("build", "--target=wvm", "-o", object, filepath.Join(rightProgramPath, "22_fib.wb")); status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	want, err := os.ReadFile(filepath.Join(outputPath, "22_fib.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, stdout, stderr := wabbit("exec", object); status != 0 || stdout != string(want) {
+		t.Errorf("exit status %d, output %q: %s", status, stdout, stderr)
+	}
+	if status, _, stderr := wabbit("exec", filepath.Join(rightProgramPath, "22_fib.wb")); status != 1 || !strings.Contains(stderr, "corrupt object file") {
+		t.Errorf("exec of a source: exit status %d: %s", status, stderr)
+	}
+}
+
+func TestDriverWvmListing(t *testing.T) {
+	dir := t.TempDir()
+	listing, object := filepath.Join(dir, "fib.wvm"), filepath.Join(dir, "fib.wbc")
+	if status, _, stderr := wabbit("wvm-dis", "-o", listing, filepath.Join(rightProgramPath, "22_fib.wb")); status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	if status, _, stderr := wabbit("wvm-as", listing); status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	want, err := os.ReadFile(filepath.Join(outputPath, "22_fib.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, stdout, stderr := wabbit("exec", object); status != 0 || stdout != string(want) {
+		t.Errorf("exit status %d, output %q: %s", status, stdout, stderr)
+	}
+	if status, stdout, _ := wabbit("wvm-dis", object); status != 0 || !strings.Contains(stdout, ".func fib(int) int locals 1\n") {
+		t.Errorf("exit status %d, listing:\n%s", status, stdout)
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"wabbit-go/common"
+	"wabbit-go/engine"
+	"wabbit-go/model"
+)
+
+const engineModule = `
+const threshold = 1000;
+var calls = 0;
+
+func in_mandelbrot(x0 float, y0 float, n int) bool {
+    var x float = 0.0;
+    var y float = 0.0;
+    var xtemp float;
+    calls = calls + 1;
+    while n > 0 {
+        xtemp = x*x - y*y + x0;
+        y = 2.0*x*y + y0;
+        x = xtemp;
+        n = n - 1;
+        if x*x + y*y > 4.0 {
+            return false;
+        }
+    }
+    return true;
+}
+
+func count() int {
+    return calls;
+}
+
+func next(c char, upper bool) char {
+    if upper {
+        return 'Z';
+    }
+    return c;
+}
+
+func half(x float) float {
+    return x / 2.0;
+}
+
+func div(x int, y int) int {
+    return x / y;
+}
+
+func loop(n int) int {
+    if n == 0 {
+        return threshold;
+    }
+    return loop(n - 1);
+}
+
+func forever() int {
+    while true {
+    }
+    return 0;
+}
+`
+
+func compileModule(t *testing.T, backend string, options common.RunOptions) *engine.Module {
+	t.Helper()
+	e, err := engine.NewEngine(backend, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := e.Compile(context.Background(), "module.wb", engineModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestEngineCall(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{"interp", "wvm"} {
+		t.Run(backend, func(t *testing.T) {
+			m := compileModule(t, backend, common.RunOptions{Stdout: io.Discard})
+			calls := []struct {
+				name string
+				args []interface{}
+				want interface{}
+			}{
+				{"in_mandelbrot", []interface{}{0.0, 0.0, int64(1000)}, true},
+				{"in_mandelbrot", []interface{}{1.0, 1.0, 1000}, false},
+				{"count", nil, int64(2)},
+				{"next", []interface{}{'a', false}, 'a'},
+				{"next", []interface{}{'a', true}, 'Z'},
+				{"half", []interface{}{3.0}, 1.5},
+				{"loop", []interface{}{int64(100000)}, int64(1000)},
+			}
+			for _, c := range calls {
+				got, err := m.Lookup(c.name).Call(ctx, c.args...)
+				if err != nil || got != c.want {
+					t.Errorf("%s%v = %v (%T), %v, want %v (%T)", c.name, c.args, got, got, err, c.want, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEngineSignatures(t *testing.T) {
+	m := compileModule(t, "interp", common.RunOptions{})
+	var signatures []string
+	for _, fn := range m.Functions() {
+		signatures = append(signatures, fn.Signature())
+	}
+	if signatures[0] != "func in_mandelbrot(x0 float, y0 float, n int) bool" || len(signatures) != 7 {
+		t.Errorf("got %q", signatures)
+	}
+	if fn := m.Lookup("in_mandelbrot"); fn.Params[2].Name != "n" || fn.Result != "bool" || fn.Decl == nil {
+		t.Errorf("got %+v", fn)
+	}
+	if m.Lookup("threshold") != nil || m.Lookup("missing") != nil {
+		t.Errorf("only functions can be looked up")
+	}
+}
+
+func TestEngineErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, backend := range []string{"interp", "wvm"} {
+		t.Run(backend, func(t *testing.T) {
+			m := compileModule(t, backend, common.RunOptions{MaxSteps: 100000})
+			div := m.Lookup("div")
+			if _, err := div.Call(ctx, int64(1)); err == nil || err.Error() != "'div' takes 2 arguments, got 1" {
+				t.Errorf("got %v", err)
+			}
+			if _, err := div.Call(ctx, 1.0, int64(1)); err == nil || err.Error() != "argument 1 of 'div' must be int, got float64" {
+				t.Errorf("got %v", err)
+			}
+
+			_, err := div.Call(ctx, int64(1), int64(0))
+			var runtimeError *model.RuntimeError
+			if !errors.As(err, &runtimeError) || runtimeError.Message != "division by zero" {
+				t.Fatalf("got %v", err)
+			}
+			if stack := runtimeError.Stack; len(stack) != 2 || stack[0].Function != "div" || stack[1].Loc.StartPos.Line != 37 {
+				t.Errorf("got stack %+v", stack)
+			}
+
+			// the limits are for each call, a failed call leaves the module usable
+			if _, err := m.Lookup("forever").Call(ctx); !errors.Is(err, common.ErrStepLimit) {
+				t.Errorf("got %v", err)
+			}
+			if got, err := div.Call(ctx, int64(7), int64(2)); got != int64(3) || err != nil {
+				t.Errorf("got %v, %v", got, err)
+			}
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			if _, err := m.Lookup("forever").Call(cancelled); !errors.Is(err, context.Canceled) {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+}
+
+func TestEngineCompileErrors(t *testing.T) {
+	e, _ := engine.NewEngine("wvm", common.RunOptions{})
+	if _, err := e.Compile(context.Background(), "bad.wb", "var x int = 1.5;"); err == nil || !strings.Contains(err.Error(), "E0") {
+		t.Errorf("got %v", err)
+	}
+	var runtimeError *model.RuntimeError
+	if _, err := e.Compile(context.Background(), "bad.wb", "print 1 / 0;"); !errors.As(err, &runtimeError) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := engine.NewEngine("llvm", common.RunOptions{}); err == nil {
+		t.Errorf("llvm can't be embedded")
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wabbit-go/common"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+)
+
+func format(t *testing.T, name string, source string) (*model.Program, string) {
+	program := model.NewProgram(source)
+	if err := parser.ParseProgram(program); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return program, model.Format(program)
+}
+
+// formatting keeps the syntax tree and the comments, and formatting twice changes nothing
+func TestFormatPrograms(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(rightProgramPath, "*.wb"))
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		program, formatted := format(t, file, string(source))
+		again, twice := format(t, file+" formatted", formatted)
+		if got, want := model.DumpNode(again.Model, nil), model.DumpNode(program.Model, nil); got != want {
+			t.Errorf("%s: formatting changed the program:\n%s", file, common.Diff("before", "after", want, got))
+		}
+		if len(again.Comments) != len(program.Comments) {
+			t.Errorf("%s: %d comments after formatting, want %d", file, len(again.Comments), len(program.Comments))
+		}
+		if twice != formatted {
+			t.Errorf("%s: formatting isn't idempotent:\n%s", file, common.Diff("once", "twice", formatted, twice))
+		}
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	source := `/* header */
+
+
+var x int=1;   // one
+func f(a int,b int) int {  // f
+  // inside
+    if a<b { return a; }
+    else { return b; }
+
+  /* at the end */
+}
+while x < 3 { x = x+1; // step
+}
+print f(x,2.0*2.0);
+// trailing
+`
+	want := `/* header */
+
+var x int = 1; // one
+func f(a int, b int) int {
+    // f
+    // inside
+    if a < b {
+        return a;
+    } else {
+        return b;
+    }
+
+    /* at the end */
+}
+while x < 3 {
+    x = x + 1; // step
+}
+print f(x, 2.0 * 2.0);
+// trailing
+`
+	_, got := format(t, "source", source)
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s\n%s", got, want, common.Diff("want", "got", want, got))
+	}
+}
+
+func TestDriverFmt(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "prog.wb")
+	if err := os.WriteFile(file, []byte("print  1+2 ;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status, stdout, _ := wabbit("fmt", "--check", file); status != 1 || strings.TrimSpace(stdout) != file {
+		t.Errorf("--check: exit status %d, output %q", status, stdout)
+	}
+	if status, stdout, _ := wabbit("fmt", "--diff", file); status != 0 || !strings.Contains(stdout, "-print  1+2 ;\n+print 1 + 2;\n") {
+		t.Errorf("--diff: exit status %d, output %q", status, stdout)
+	}
+	if status, _, _ := wabbit("fmt", "--write", file); status != 0 {
+		t.Errorf("--write: exit status %d", status)
+	}
+	if source, _ := os.ReadFile(file); string(source) != "print 1 + 2;\n" {
+		t.Errorf("--write wrote %q", source)
+	}
+	if status, stdout, _ := wabbit("fmt", "--check", file); status != 0 || stdout != "" {
+		t.Errorf("--check after --write: exit status %d, output %q", status, stdout)
+	}
+}
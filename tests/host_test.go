@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"wabbit-go/check"
+	"wabbit-go/common"
+	"wabbit-go/engine"
+	"wabbit-go/llvm"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+	"wabbit-go/wasm"
+)
+
+var errNoInput = errors.New("no more input")
+
+// testHost reads ints from input
+func testHost(t *testing.T, input ...int64) *model.Host {
+	host := model.NewHost()
+	register := func(signature string, fn model.HostFunc) {
+		if err := host.Register(signature, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register("sqrt(float) float", func(args []interface{}) (interface{}, error) {
+		return math.Sqrt(args[0].(float64)), nil
+	})
+	register("read_int() int", func(args []interface{}) (interface{}, error) {
+		if len(input) == 0 {
+			return nil, errNoInput
+		}
+		n := input[0]
+		input = input[1:]
+		return n, nil
+	})
+	register("upper(char, bool) char", func(args []interface{}) (interface{}, error) {
+		if args[1].(bool) {
+			return args[0].(rune) - 'a' + 'A', nil
+		}
+		return args[0], nil
+	})
+	return host
+}
+
+// hostProgram parses and checks source calling the functions of host
+func hostProgram(source string, host *model.Host) (*model.Program, []model.Diagnostic) {
+	program := model.NewProgram(source)
+	program.Host = host
+	if err := parser.ParseProgram(program); err != nil {
+		return program, []model.Diagnostic{{Message: err.Error()}}
+	}
+	return program, check.CheckProgram(program)
+}
+
+const hostSource = `
+func hypot(x float, y float) float {
+    return sqrt(x*x + y*y);
+}
+var n = read_int();
+var total = 0;
+while n > 0 {
+    total = total + read_int();
+    n = n - 1;
+}
+print total;
+print hypot(3.0, 4.0);
+print upper('w', true);
+print upper('b', 1 > 2);
+`
+
+func TestHostFunctions(t *testing.T) {
+	for _, backend := range limitedBackends {
+		program, diagnostics := hostProgram(hostSource, testHost(t, 3, 10, 20, 12))
+		if len(diagnostics) > 0 {
+			t.Fatal(diagnostics)
+		}
+		var stdout strings.Builder
+		if err := backend.run(context.Background(), program, common.RunOptions{Stdout: &stdout}); err != nil {
+			t.Errorf("%s: %v", backend.name, err)
+		}
+		if got := stdout.String(); got != "42\n5\nWb" {
+			t.Errorf("%s: got %q", backend.name, got)
+		}
+
+		// an error of the host stops the program at the call
+		program, _ = hostProgram(hostSource, testHost(t, 3, 10))
+		err := backend.run(context.Background(), program, common.RunOptions{Stdout: &stdout})
+		var runtimeError *model.RuntimeError
+		if !errors.Is(err, errNoInput) || !errors.As(err, &runtimeError) || runtimeError.Loc.StartPos.Line != 8 {
+			t.Errorf("%s: got %v", backend.name, err)
+		}
+	}
+}
+
+func TestHostFunctionsChecked(t *testing.T) {
+	cases := []struct {
+		source string
+		want   string
+	}{
+		{"print sqrt(2);", "argument 1 of 'sqrt' must be float, got int"},
+		{"print sqrt();", "function 'sqrt' takes 1 arguments, got 0"},
+		{"var c char = read_int();", "E0204"},
+		{"func sqrt(x float) float { return x; }", "can't use host function name 'sqrt' as a name"},
+		{"var read_int = 1;", "can't use host function name 'read_int' as a name"},
+	}
+	for _, c := range cases {
+		_, diagnostics := hostProgram(c.source, testHost(t))
+		if !strings.Contains(model.Diagnostics(diagnostics).Error(), c.want) {
+			t.Errorf("%s: got %v, want %s", c.source, diagnostics, c.want)
+		}
+	}
+	// without the host they are undefined
+	if _, diagnostics := hostProgram("print sqrt(2.0);", nil); len(diagnostics) != 1 || diagnostics[0].Code != check.ErrUndefined {
+		t.Errorf("got %v", diagnostics)
+	}
+}
+
+func TestHostRegister(t *testing.T) {
+	host := model.NewHost()
+	for signature, want := range map[string]string{
+		"f(int":            "want name(types) type",
+		"f(int) string":    "unknown type 'string'",
+		"f(int, ) int":     "unknown type ''",
+		"f()":              "unknown type ''",
+		"2f() int":         "bad name '2f'",
+		"float(int) float": "bad name 'float'",
+	} {
+		if err := host.Register(signature, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %s", signature, err, want)
+		}
+	}
+	if err := host.Register(" clock ( ) float ", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := host.Register("clock() int", nil); err == nil {
+		t.Errorf("registered clock twice")
+	}
+	if f := host.Lookup("clock"); f == nil || f.Signature() != "clock() float" {
+		t.Errorf("got %v", f)
+	}
+}
+
+func TestHostImports(t *testing.T) {
+	program, diagnostics := hostProgram("print sqrt(2.0) + float(read_int());", testHost(t))
+	if len(diagnostics) > 0 {
+		t.Fatal(diagnostics)
+	}
+	wat := wasm.Wasm(program)
+	for _, want := range []string{
+		`(import "env" "sqrt" (func $sqrt ( param f64 ) ( result f64 )))`,
+		`(import "env" "read_int" (func $read_int ( result i32 )))`,
+		`(import "env" "upper" (func $upper ( param i32 ) ( param i32 ) ( result i32 )))`,
+		"call $sqrt",
+	} {
+		if !strings.Contains(wat, want) {
+			t.Errorf("wasm doesn't have %s", want)
+		}
+	}
+	ll := llvm.LLVM(program)
+	for _, want := range []string{
+		`declare double @"sqrt"(double %".1")`,
+		`declare i64 @"read_int"()`,
+		`declare i8 @"upper"(i8 %".1", i1 %".2")`,
+		`call double @"sqrt"(double`,
+	} {
+		if !strings.Contains(ll, want) {
+			t.Errorf("llvm doesn't have %s", want)
+		}
+	}
+}
+
+func TestEngineHostFunctions(t *testing.T) {
+	for _, backend := range []string{"interp", "wvm"} {
+		e, _ := engine.NewEngine(backend, common.RunOptions{})
+		err := e.Register("scale(int) float", func(args []interface{}) (interface{}, error) {
+			return float64(args[0].(int64)) * 1.5, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := e.Compile(context.Background(), "host.wb", "func f(n int) float { return scale(n) + 1.0; }")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := m.Lookup("f").Call(context.Background(), int64(2)); got != 4.0 || err != nil {
+			t.Errorf("%s: got %v, %v", backend, got, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wabbit-go/check"
+	"wabbit-go/common"
+	"wabbit-go/interpreter"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+)
+
+func checkSource(t testing.TB, source string) *model.Program {
+	program := model.NewProgram(source)
+	if err := parser.ParseProgram(program); err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics := check.CheckProgram(program); len(diagnostics) > 0 {
+		t.Fatal(model.Diagnostics(diagnostics))
+	}
+	return program
+}
+
+func TestInterpretBlockScopes(t *testing.T) {
+	program := checkSource(t, `
+var x = 1;
+func f(n int) int {
+    var y = n;
+    if n > 0 {
+        var y = 10;
+        x = x + y;
+    }
+    return y;
+}
+while x < 30 {
+    var x = 100;
+    print x;
+    break;
+}
+print f(3);
+print x;
+print {var t = x; t * 2;};
+`)
+	want := "100\n3\n11\n22\n"
+	for _, engine := range []func(*model.Program, common.RunOptions) error{interpret, runClosures} {
+		if got := run(t, engine, program); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func benchmarkEngine(b *testing.B, name string, engine func(*model.Program, common.RunOptions) error) {
+	program := loadProgram(b, filepath.Join(rightProgramPath, name))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := engine(program, common.RunOptions{Stdout: io.Discard}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInterpretMandel(b *testing.B) {
+	benchmarkEngine(b, "23_mandel.wb", interpret)
+}
+
+func BenchmarkClosuresMandel(b *testing.B) {
+	benchmarkEngine(b, "23_mandel.wb", runClosures)
+}
+
+func BenchmarkInterpretFib(b *testing.B) {
+	benchmarkEngine(b, "22_fib.wb", interpret)
+}
+
+func BenchmarkClosuresFib(b *testing.B) {
+	benchmarkEngine(b, "22_fib.wb", runClosures)
+}
+
+var engines = []struct {
+	name string
+	run  func(*model.Program, common.RunOptions) error
+}{
+	{"tree", interpreter.InterpretProgram},
+	{"closure", interpreter.CompileProgram},
+}
+
+func TestInterpretTailCalls(t *testing.T) {
+	// far deeper than MaxDepth, only the tail calls run this deep
+	program := checkSource(t, `
+func count(n int, acc int) int {
+    if n == 0 {
+        return acc;
+    }
+    return count(n - 1, acc + 1);
+}
+func start(n int) int {
+    while true {
+        return count(n, 0);
+    }
+    return 0;
+}
+print count(1000000, 0);
+print start(1000000);
+`)
+	for _, engine := range engines {
+		var stdout strings.Builder
+		if err := engine.run(program, common.RunOptions{Stdout: &stdout, MaxDepth: 100}); err != nil {
+			t.Fatalf("%s: %v", engine.name, err)
+		}
+		if got, want := stdout.String(), "1000000\n1000000\n"; got != want {
+			t.Errorf("%s: got %q, want %q", engine.name, got, want)
+		}
+	}
+}
+
+func TestInterpretStackOverflow(t *testing.T) {
+	program := checkSource(t, `
+func down(n int) int {
+    if n == 0 {
+        return 0;
+    }
+    return 1 + down(n - 1);
+}
+print down(50);
+print down(500);
+`)
+	for _, engine := range engines {
+		var stdout strings.Builder
+		err := engine.run(program, common.RunOptions{Stdout: &stdout, MaxDepth: 100})
+		if got, want := stdout.String(), "50\n"; got != want {
+			t.Errorf("%s: got %q, want %q", engine.name, got, want)
+		}
+		if err == nil || err.Error() != "stack overflow at line 6" {
+			t.Errorf("%s: got error %v, want a stack overflow at line 6", engine.name, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+	"wabbit-go/tokenize"
+)
+
+func tokens(lexer *tokenize.Lexer) []tokenize.Token {
+	var toks []tokenize.Token
+	for {
+		tok := lexer.Next()
+		toks = append(toks, tok)
+		if tok.Type == "EOF" {
+			return toks
+		}
+	}
+}
+
+// reading a byte at a time must give the same tokens as scanning a string
+func TestLexerReader(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(rightProgramPath, "*.wb"))
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tokens(tokenize.NewStringLexer(file, string(source)))
+		got := tokens(tokenize.NewLexer(file, iotest.OneByteReader(strings.NewReader(string(source)))))
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d tokens, want %d", file, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: token %d is %v, want %v", file, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestLexerBacktrack(t *testing.T) {
+	lexer := tokenize.NewStringLexer("", "var x = 1;")
+	if tok := lexer.Next(); tok.Type != "VAR" {
+		t.Fatalf("got %v", tok)
+	}
+	if tok := lexer.Peek(2); tok.Type != "INTEGER" {
+		t.Fatalf("peek got %v", tok)
+	}
+	mark := lexer.Mark()
+	lexer.Next()
+	lexer.Next()
+	lexer.Reset(mark)
+	if tok := lexer.Next(); tok.Type != "ID" || tok.Value != "x" {
+		t.Fatalf("after reset got %v", tok)
+	}
+	lexer.Next()
+	lexer.Next()
+	lexer.Next()
+	for i := 0; i < 2; i++ {
+		if tok := lexer.Next(); tok.Type != "EOF" {
+			t.Fatalf("got %v, want EOF", tok)
+		}
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	// a large generated program
+	var sb strings.Builder
+	for i := 0; i < 2000; i++ {
+		sb.WriteString("var x")
+		sb.WriteString(strings.Repeat("y", i%7))
+		sb.WriteString(" int = (1 + 2) * 3 - 4 / 5;\nif 1 < 2 { print 1; } else { print 2; }\n")
+	}
+	source := sb.String()
+	b.SetBytes(int64(len(source)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser.ParseProgram(model.NewProgram(source))
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"wabbit-go/common"
+	"wabbit-go/interpreter"
+	"wabbit-go/model"
+	"wabbit-go/wvm"
+)
+
+var limitedBackends = []struct {
+	name string
+	run  func(context.Context, *model.Program, common.RunOptions) error
+}{
+	{"tree", interpreter.InterpretProgramContext},
+	{"closure", interpreter.CompileProgramContext},
+	{"wvm", wvm.WvmContext},
+}
+
+const forever = `
+var n = 0;
+while true {
+    n = n + 1;
+}
+`
+
+func TestLimits(t *testing.T) {
+	cases := []struct {
+		name    string
+		source  string
+		options common.RunOptions
+		want    error
+		stdout  string
+	}{
+		{"steps", forever, common.RunOptions{MaxSteps: 10000}, common.ErrStepLimit, ""},
+		{"timeout", forever, common.RunOptions{Timeout: 20 * time.Millisecond}, common.ErrTimeout, ""},
+		{"empty loop", "while true {}", common.RunOptions{MaxSteps: 10000}, common.ErrStepLimit, ""},
+		{"output", "while true { print 12345; }", common.RunOptions{MaxOutput: 15}, common.ErrOutputLimit, "12345\n12345\n123"},
+		{"depth", "func f(n int) int { return 1 + f(n); }\nprint f(0);", common.RunOptions{MaxDepth: 50}, common.ErrDepthLimit, ""},
+	}
+	for _, c := range cases {
+		program := checkSource(t, c.source)
+		for _, backend := range limitedBackends {
+			var stdout strings.Builder
+			options := c.options
+			options.Stdout = &stdout
+			err := backend.run(context.Background(), program, options)
+			if !errors.Is(err, c.want) {
+				t.Errorf("%s %s: got %v, want %v", c.name, backend.name, err, c.want)
+				continue
+			}
+			var runtimeError *model.RuntimeError
+			if !errors.As(err, &runtimeError) || runtimeError.Loc.StartPos.Line == 0 {
+				t.Errorf("%s %s: %v doesn't say where the program stopped", c.name, backend.name, err)
+			}
+			if stdout.String() != c.stdout {
+				t.Errorf("%s %s: printed %q, want %q", c.name, backend.name, stdout.String(), c.stdout)
+			}
+		}
+	}
+}
+
+func TestLimitsCancel(t *testing.T) {
+	program := checkSource(t, forever)
+	for _, backend := range limitedBackends {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		if err := backend.run(ctx, program, common.RunOptions{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want %v", backend.name, err, context.Canceled)
+		}
+	}
+}
+
+func TestLimiterSteps(t *testing.T) {
+	for _, max := range []int64{1, 1023, 1024, 1025, 5000} {
+		limiter := common.NewLimiter(context.Background(), &common.RunOptions{MaxSteps: max})
+		var steps int64
+		for limiter.Step() == nil {
+			steps++
+		}
+		if steps != max || limiter.Steps() != max+1 {
+			t.Errorf("max %d: took %d steps, counted %d", max, steps, limiter.Steps())
+		}
+	}
+}
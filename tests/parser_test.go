@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+)
+
+func TestParseParserPrograms(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join("Parser", "*.wb"))
+	for _, file := range files {
+		if _, err := parser.HandleFile(file); err != nil {
+			t.Errorf("%s: %v", file, err)
+		}
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	cases := []struct {
+		source string
+		code   string
+		line   int
+		column int
+	}{
+		{"var x int = 3\nprint x;\n", parser.ErrExpected, 2, 1},
+		{"print (1 + 2;\n", parser.ErrExpected, 1, 13},
+		{"func f(x int {\n}\n", parser.ErrExpected, 1, 14},
+		{"func (x int) int { return x; }\n", parser.ErrExpected, 1, 6},
+		{"print 1 + ;\n", parser.ErrUnexpected, 1, 11},
+		{"print 1;\n}\n", parser.ErrUnexpected, 2, 1},
+		{"if true { print 1;\n", parser.ErrExpected, 2, 1},
+		{"print 99999999999999999999;\n", parser.ErrLiteral, 1, 7},
+	}
+	for _, c := range cases {
+		program := model.NewProgram(c.source)
+		err := parser.ParseProgram(program)
+		diagnostics, ok := err.(model.Diagnostics)
+		if !ok || len(diagnostics) == 0 {
+			t.Errorf("%q: expected diagnostics, got %v", c.source, err)
+			continue
+		}
+		d := diagnostics[0]
+		if d.Code != c.code || d.Line != c.line || d.Column != c.column {
+			t.Errorf("%q: got %v, want %s at %d:%d", c.source, d, c.code, c.line, c.column)
+		}
+		if !program.HaveErrors {
+			t.Errorf("%q: diagnostics not reported to the program", c.source)
+		}
+	}
+}
+
+// every prefix of a valid program is some kind of broken program, none may panic
+func TestParsePrefixesDontPanic(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(rightProgramPath, "*.wb"))
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(source); n += 7 {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s: panic on prefix of %d bytes: %v", file, n, r)
+					}
+				}()
+				parser.ParseProgram(model.NewProgram(string(source[:n])))
+			}()
+		}
+	}
+}
+
+func TestSyntaxErrorRecovery(t *testing.T) {
+	source := `var x int = 3
+print (1 + 2;
+func f(x int {
+    return x;
+}
+if x > 1 {
+    print 1 +;
+    print 2;
+} else {
+    print 3;
+}
+const y = ;
+print x;
+`
+	program := model.NewProgram(source)
+	err := parser.ParseProgram(program)
+	diagnostics, _ := err.(model.Diagnostics)
+	lines := []int{2, 3, 7, 12}
+	if len(diagnostics) != len(lines) {
+		t.Fatalf("got %d diagnostics, want %d:\n%v", len(diagnostics), len(lines), err)
+	}
+	for i, line := range lines {
+		if diagnostics[i].Line != line {
+			t.Errorf("diagnostic %d is on line %d, want %d", i, diagnostics[i].Line, line)
+		}
+	}
+	// the if statement and the last print survive
+	statements := program.Model.(*model.Statements).Statements
+	if len(statements) != 2 {
+		t.Fatalf("got %d statements in the partial AST, want 2", len(statements))
+	}
+	if _, ok := statements[0].(*model.IfStatement); !ok {
+		t.Errorf("got %T, want *model.IfStatement", statements[0])
+	}
+	if _, ok := statements[1].(*model.PrintStatement); !ok {
+		t.Errorf("got %T, want *model.PrintStatement", statements[1])
+	}
+}
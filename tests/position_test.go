@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"testing"
+	"wabbit-go/model"
+	"wabbit-go/parser"
+	"wabbit-go/tokenize"
+)
+
+func TestLineTable(t *testing.T) {
+	source := "var c char = 'é';\nprint c;\n"
+	lines := model.NewLineTable(source)
+	for offset := 0; offset <= len(source); offset++ {
+		pos := lines.Position(offset)
+		if offset == 15 {
+			// inside the two bytes of é
+			continue
+		}
+		if got := lines.Offset(pos.Line, pos.Column); got != offset {
+			t.Errorf("offset %d -> %v -> %d", offset, pos, got)
+		}
+	}
+	// the closing quote is the 16th character but the 17th byte
+	if pos := lines.Position(16); pos.Line != 1 || pos.Column != 16 {
+		t.Errorf("got %v, want 1:16", pos)
+	}
+	if pos := lines.Position(len(source)); pos.Line != 3 || pos.Column != 1 {
+		t.Errorf("got %v, want 3:1", pos)
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	lexer := tokenize.NewStringLexer("test.wb", "var c char = 'é';\n  print c;\n")
+	want := []string{"1:1-1:4", "1:5-1:6", "1:7-1:11", "1:12-1:13", "1:14-1:17", "1:17-1:18",
+		"2:3-2:8", "2:9-2:10", "2:10-2:11", "3:1-3:1"}
+	toks := tokens(lexer)
+	if len(toks) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(toks), len(want))
+	}
+	for i, tok := range toks {
+		if got := tok.Pos.String() + "-" + tok.End.String(); got != want[i] || tok.File != "test.wb" {
+			t.Errorf("token %v is at %s in %q, want %s", tok, got, tok.File, want[i])
+		}
+	}
+}
+
+func TestLocatorEnd(t *testing.T) {
+	program := model.NewProgram("print 1 +\n  2;\n")
+	program.File = "test.wb"
+	if err := parser.ParseProgram(program); err != nil {
+		t.Fatal(err)
+	}
+	print := program.Model.(*model.Statements).Statements[0].(*model.PrintStatement)
+	loc := program.Location(print.Value)
+	if got, want := loc.String(), "test.wb:1:7-2:4"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"wabbit-go/common"
+	"wabbit-go/driver"
+)
+
+// repl types lines into a new Repl and gives what it printed
+func repl(lines ...string) (string, string) {
+	var stdout, stderr strings.Builder
+	r := driver.NewRepl(&stdout, &stderr, common.RunOptions{})
+	for _, line := range lines {
+		r.Line(line)
+	}
+	return stdout.String(), stderr.String()
+}
+
+func TestReplDefinitionsPersist(t *testing.T) {
+	stdout, stderr := repl(
+		"var x = 3;",
+		"const k = 10;",
+		"func add(a int, b int) int {",
+		"    return a + b;",
+		"}",
+		"print add(x, k);",
+		"x = x + 1;",
+		"add(x, k)",
+	)
+	if stdout != "13\n14\n" || stderr != "" {
+		t.Errorf("got stdout %q stderr %q", stdout, stderr)
+	}
+}
+
+func TestReplEcho(t *testing.T) {
+	stdout, stderr := repl("1 + 2", "2.0 * 1.5;", "// just a comment", "1 < 2", "'a'", "var y = 1;")
+	if stdout != "3\n3.0\ntrue\n'a'\n" || stderr != "" {
+		t.Errorf("got stdout %q stderr %q", stdout, stderr)
+	}
+}
+
+// the ; left out goes before a comment ending the line
+func TestReplTrailingComment(t *testing.T) {
+	stdout, stderr := repl(
+		"var x = 1 // one",
+		"x + 1 // note",
+		":type x + 1 // note",
+		":wvm x + 1 /* note */",
+		":ast x // note",
+	)
+	want := "2\nint\nILOAD_GLOBAL 0\nIPUSH 1\nIADD\nName Text=\"x\" @1:1-1:2\n"
+	if stdout != want || stderr != "" {
+		t.Errorf("got stdout %q stderr %q, want %q", stdout, stderr, want)
+	}
+}
+
+// :wvm lists the code of just its expression, with the names defined before
+func TestReplWvmListing(t *testing.T) {
+	stdout, stderr := repl(
+		"var a = 1; print 1 / 0; var b = 2.5;",
+		"func f(n int) int { return n; }",
+		":wvm b",
+		"f(a)",
+		":wvm f(a)",
+		":wvm f(a)",
+	)
+	want := "FLOAD_GLOBAL 1\n1\nILOAD_GLOBAL 0\nCALL 0\nILOAD_GLOBAL 0\nCALL 0\n"
+	if stdout != want || !strings.Contains(stderr, "division by zero") {
+		t.Errorf("got stdout %q stderr %q, want %q", stdout, stderr, want)
+	}
+}
+
+func TestReplMultiLine(t *testing.T) {
+	stdout, stderr := repl(
+		"var i = 0;",
+		"while i < 3 {",
+		"    if i == 1 {",
+		"        print i;",
+		"    }",
+		"    i = i + 1;",
+		"}",
+		"/* a comment",
+		"   over lines */ i",
+	)
+	if stdout != "1\n3\n" || stderr != "" {
+		t.Errorf("got stdout %q stderr %q", stdout, stderr)
+	}
+}
+
+func TestReplErrorsKeepSession(t *testing.T) {
+	stdout, stderr := repl(
+		"var x = 1;",
+		"var z = x + 1.5;",
+		"z",
+		"x / 0",
+		"x = x + 1;",
+		"var x = 2.5;",
+		"x",
+	)
+	if stdout != "2.5\n" {
+		t.Errorf("got stdout %q", stdout)
+	}
+	for _, want := range []string{"E0204", "undefined", "division by zero"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr %q doesn't have %q", stderr, want)
+		}
+	}
+}
+
+func TestReplCommands(t *testing.T) {
+	stdout, stderr := repl(
+		"func sq(n int) int { return n * n; }",
+		":type sq(2) < 3",
+		":ast 1 + 2",
+		":wvm sq(3)",
+		":type",
+		":nope",
+	)
+	want := "bool\nAdd @1:1-1:6\n  Left: Integer Value=1 @1:1-1:2\n  Right: Integer Value=2 @1:5-1:6\nIPUSH 3\nCALL 0\n"
+	if stdout != want {
+		t.Errorf("got stdout %q, want %q", stdout, want)
+	}
+	if !strings.Contains(stderr, ":type needs an expression") || !strings.Contains(stderr, "unknown command :nope") {
+		t.Errorf("got stderr %q", stderr)
+	}
+}
@@ -1,11 +1,13 @@
 package tests
 
 import (
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
 	"testing"
+	"wabbit-go/check"
+	"wabbit-go/common"
 	"wabbit-go/interpreter"
 	"wabbit-go/llvm"
 	"wabbit-go/parser" // Update this import path
@@ -15,6 +17,7 @@ import (
 
 var rightProgramPath string
 var wrongProgramPath string
+var errorProgramPath string
 
 func init() {
 	wd, err := os.Getwd()
@@ -23,6 +26,7 @@ func init() {
 	}
 	rightProgramPath = filepath.Join(wd, "Programs")
 	wrongProgramPath = filepath.Join(wd, "ErrorLex")
+	errorProgramPath = filepath.Join(wd, "Error")
 }
 
 func TestRightProgram(t *testing.T) {
@@ -35,13 +39,19 @@ func TestRightProgram(t *testing.T) {
 
 		if err != nil {
 			t.Errorf(err.Error())
+			continue
 		}
-		if !strings.HasSuffix(rightFile, "25_tailrecurisve.wb") {
-			// can't handle the tail deep recursive
-			interpreter.InterpretProgram(p)
+		if diags := check.CheckProgram(p); len(diags) > 0 {
+			t.Errorf("%s: %v", rightFile, diags)
+			continue
+		}
+		if err := interpreter.InterpretProgram(p, common.RunOptions{Stdout: io.Discard}); err != nil {
+			t.Errorf("%s: %v", rightFile, err)
 		}
 
-		wvm.Wvm(p)
+		if err := wvm.Wvm(p, common.RunOptions{Stdout: io.Discard}); err != nil {
+			t.Errorf("%s: %v", rightFile, err)
+		}
 		wasm.Wasm(p)
 		llvm.LLVM(p)
 	}
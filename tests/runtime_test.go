@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"wabbit-go/common"
+	"wabbit-go/interpreter"
+	"wabbit-go/model"
+	"wabbit-go/wvm"
+)
+
+var stoppingBackends = []struct {
+	name string
+	run  func(*model.Program, common.RunOptions) error
+}{
+	{"tree", interpreter.InterpretProgram},
+	{"closure", interpreter.CompileProgram},
+	{"wvm", wvm.Wvm},
+}
+
+func TestRuntimeErrorDivisionByZero(t *testing.T) {
+	source := `func div(x int, y int) int {
+    return x / y;
+}
+func half(x int) int {
+    var r = div(x, 2);
+    return r + div(x, 0);
+}
+print half(10);
+`
+	program := checkSource(t, source)
+	want := []string{"div 2:12", "half 6:16", "<program> 8:7"}
+	for _, backend := range stoppingBackends {
+		err := backend.run(program, common.RunOptions{Stdout: io.Discard})
+		var runtimeError *model.RuntimeError
+		if !errors.As(err, &runtimeError) {
+			t.Fatalf("%s: got %v, want a runtime error", backend.name, err)
+		}
+		if got := runtimeError.Error(); got != "division by zero at line 2" {
+			t.Errorf("%s: got %q", backend.name, got)
+		}
+		if got := runtimeError.Loc.Source(); got != "x / y" {
+			t.Errorf("%s: error at %q, want x / y", backend.name, got)
+		}
+		var stack []string
+		for _, frame := range runtimeError.Stack {
+			stack = append(stack, frame.Function+" "+frame.Loc.StartPos.String())
+		}
+		if strings.Join(stack, ", ") != strings.Join(want, ", ") {
+			t.Errorf("%s: stack %v, want %v", backend.name, stack, want)
+		}
+		rendered := model.RenderError(err, source)
+		if !strings.Contains(rendered, "2 |     return x / y;\n") || !strings.Contains(rendered, "  half at <input>:6:16\n") {
+			t.Errorf("%s: rendered as\n%s", backend.name, rendered)
+		}
+	}
+}
+
+func TestRuntimeErrorDeepStack(t *testing.T) {
+	err := &model.RuntimeError{Message: "stack overflow"}
+	for i := 0; i < 100; i++ {
+		err.Stack = append(err.Stack, model.StackFrame{Function: "f"})
+	}
+	rendered := err.Render("")
+	if !strings.Contains(rendered, "  ... 80 more\n") || strings.Count(rendered, "  f at") != 20 {
+		t.Errorf("rendered as\n%s", rendered)
+	}
+}
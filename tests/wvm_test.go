@@ -0,0 +1,318 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"wabbit-go/common"
+	"wabbit-go/model"
+	"wabbit-go/wvm"
+)
+
+// object compiles program to an object file and loads it back
+func object(t *testing.T, program *model.Program, strip bool) *wvm.Bytecode {
+	bytecode, err := wvm.Compile(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strip {
+		bytecode.Strip()
+	}
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := wvm.ReadObject(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+// every program runs the same from its object file
+func TestWvmObjectRoundTrip(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(rightProgramPath, "*.wb"))
+	for _, file := range files {
+		if strings.Contains(file, "mandel") {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".wb")
+		want, err := os.ReadFile(filepath.Join(outputPath, name+".out"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		bytecode := object(t, loadProgram(t, file), false)
+		if err := wvm.Exec(context.Background(), bytecode, nil, common.RunOptions{Stdout: &stdout}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if stdout.String() != string(want) {
+			t.Errorf("%s:\n%s", name, common.Diff("want", "got", string(want), stdout.String()))
+		}
+	}
+}
+
+func TestWvmObjectRuntimeError(t *testing.T) {
+	program := checkSource(t, `func div(x int, y int) int {
+    return x / y;
+}
+print div(1, 0);
+`)
+	for _, strip := range []bool{false, true} {
+		err := wvm.Exec(context.Background(), object(t, program, strip), nil, common.RunOptions{Stdout: io.Discard})
+		var runtimeError *model.RuntimeError
+		if !errors.As(err, &runtimeError) {
+			t.Fatalf("got %v, want a runtime error", err)
+		}
+		var stack []string
+		for _, frame := range runtimeError.Stack {
+			stack = append(stack, frame.Function+" "+frame.Loc.StartPos.String())
+		}
+		want := "div 2:12, <program> 4:7"
+		if strip {
+			want = "div 0:0, <program> 0:0"
+		}
+		if got := strings.Join(stack, ", "); got != want {
+			t.Errorf("strip %v: stack %s, want %s", strip, got, want)
+		}
+	}
+}
+
+func TestWvmObjectRejected(t *testing.T) {
+	bytecode, err := wvm.Compile(checkSource(t, "print 1 + 2;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := bytecode.MarshalBinary()
+	flipped := bytes.Clone(data)
+	flipped[len(flipped)/2] ^= 0xff
+	newer := bytes.Clone(data)
+	binary.LittleEndian.PutUint16(newer[4:], wvm.ObjectVersion+1)
+	// sizes the code doesn't use, with a valid checksum
+	sized := func(globals int, locals int) []byte {
+		bytecode, err := wvm.Compile(checkSource(t, "var x = 1;\nfunc f(n int) int {\n    return n;\n}\nprint f(x);\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bytecode.Globals += globals
+		bytecode.Functions[0].Locals += locals
+		data, _ := bytecode.MarshalBinary()
+		return data
+	}
+	huge, err := wvm.AssembleText(".globals 2147483649\nILOAD_GLOBAL 2147483648\nIPOP\nHALT\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hugeSlot, _ := huge.MarshalBinary()
+	cases := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, wvm.ErrCorruptObject},
+		{"source", []byte("print 1;\n"), wvm.ErrCorruptObject},
+		{"truncated", data[:len(data)-5], wvm.ErrCorruptObject},
+		{"flipped", flipped, wvm.ErrCorruptObject},
+		{"version", newer, wvm.ErrObjectVersion},
+		{"sized", sized(0, 0), nil},
+		{"globals", sized(0x7fffffff, 0), wvm.ErrCorruptObject},
+		{"locals", sized(0, 0x7fffffff), wvm.ErrCorruptObject},
+		{"slot", hugeSlot, wvm.ErrCorruptObject},
+	}
+	for _, c := range cases {
+		if _, err := wvm.ReadObject(c.data); !errors.Is(err, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.want)
+		}
+	}
+}
+
+// the listing of every program assembles back to the same bytecode
+func TestWvmListingRoundTrip(t *testing.T) {
+	files, _ := filepath.Glob(filepath.Join(rightProgramPath, "*.wb"))
+	for _, file := range files {
+		program := loadProgram(t, file)
+		bytecode, err := wvm.Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listing := wvm.Disassemble(bytecode, program.Source)
+		assembled, err := wvm.AssembleText(listing)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if !bytes.Equal(assembled.Code, bytecode.Code) || assembled.Globals != bytecode.Globals || assembled.File != bytecode.File {
+			t.Errorf("%s assembled differently", file)
+		}
+		again := wvm.Disassemble(assembled, "")
+		if listed := wvm.Disassemble(bytecode, ""); !sameListing(again, listed) {
+			t.Errorf("%s:\n%s", file, common.Diff("listing", "assembled", listed, again))
+		}
+	}
+}
+
+// sameListing compares listings without their line annotations
+func sameListing(a, b string) bool {
+	clean := func(listing string) string {
+		lines := strings.Split(listing, "\n")
+		for i, line := range lines {
+			line, _, _ = strings.Cut(line, ";")
+			lines[i] = strings.TrimRight(line, " ")
+		}
+		return strings.Join(lines, "\n")
+	}
+	return clean(a) == clean(b)
+}
+
+func TestWvmAssembleText(t *testing.T) {
+	bytecode, err := wvm.AssembleText(`; prints 3 squares, the hard way
+.globals 1
+	IPUSH 1
+	ISTORE_GLOBAL 0
+loop:
+	ILOAD_GLOBAL 0
+	IPUSH 3
+	ICMP <=
+	BZ done
+	ILOAD_GLOBAL 0
+	CALL square
+	PRINTI
+	ILOAD_GLOBAL 0
+	IPUSH 1
+	IADD
+	ISTORE_GLOBAL 0
+	GOTO loop
+
+.func square(int) int locals 1
+	ISTORE_LOCAL 0
+	ILOAD_LOCAL 0
+	ILOAD_LOCAL 0
+	IMUL
+	RETURN
+done:
+	HALT
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	if err := wvm.Exec(context.Background(), bytecode, nil, common.RunOptions{Stdout: &stdout}); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "1\n4\n9\n" {
+		t.Errorf("printed %q", stdout.String())
+	}
+
+	for text, want := range map[string]string{
+		"IPUSH":                     "line 1: IPUSH needs an operand",
+		"IADD 1":                    "line 1: IADD takes no operand",
+		"IPUSH x":                   "line 1: bad operand of IPUSH",
+		"ICMP =<":                   "line 1: no such comparison =<",
+		"NOP":                       "line 1: no such opcode NOP",
+		"GOTO nowhere\nHALT":        "line 1: no label nowhere",
+		"a:\na:":                    "line 2: label a defined twice",
+		".func f(int) int":          "line 1: want .func name(types) type locals n",
+		".func f(str) int locals 0": "line 1: unknown type 'str'",
+	} {
+		if _, err := wvm.AssembleText(text); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: got %v, want %s", text, err, want)
+		}
+	}
+}
+
+func TestWvmVerify(t *testing.T) {
+	for text, want := range map[string]string{
+		"IADD\nHALT":                       "<program> at 0, IADD: pops 2 ints and 0 floats from stacks of 0 and 0",
+		"FPUSH 1\nPRINTI\nHALT":            "<program> at 9, PRINTI: pops 1 ints and 0 floats",
+		"IPUSH 1\nBZ a\nIPUSH 2\na:\nHALT": "<program> at 23: stacks of 1 ints and 0 floats, 0 and 0 on another path",
+		"IPUSH 1\nPRINTI":                  "<program> at 9, PRINTI: runs past the end of the code",
+		"IPUSH 1\nRETURN":                  "<program> at 9, RETURN: return outside of a function",
+		"ILOAD_LOCAL 0\nHALT":              "<program> at 0, ILOAD_LOCAL 0: slot 0 of 0 locals",
+		".globals 1\nIPUSH 1\nISTORE_GLOBAL 0\nFLOAD_GLOBAL 0\nHALT": "FLOAD_GLOBAL 0: slot 0 holds ints, not floats",
+		"a:\nCALL a\nHALT": "<program> at 0, CALL .L0: call to 0, not a function",
+		"HALT\n.func f(int) float locals 1\nIPUSH 1\nRETURN":        "f at 10, RETURN: return of float with stacks of 2 ints and 0 floats",
+		"HALT\n.func f() int locals 0\nIPUSH 1\nIPUSH 2\nRETURN":    "f at 19, RETURN: return of int with stacks of 2 ints",
+		"IPUSH 1\nx:\nPRINTI\nHALT\n.func g() int locals 0\nGOTO x": "g jumps to 9 in <program>",
+		".globals 2\nIPUSH 1\nISTORE_GLOBAL 0\nHALT":                "2 globals, the code uses 1",
+		"HALT\n.func f() int locals 2147483647\nIPUSH 1\nRETURN":    "function 'f' has 2147483647 locals, there can be 65536",
+		"HALT\n.func f(int) int locals 3\nISTORE_LOCAL 0\nILOAD_LOCAL 0\nRETURN\n.func g() int locals 3\nIPUSH 1\nISTORE_LOCAL 2\nILOAD_LOCAL 2\nRETURN": "function 'f' has 3 locals, the code uses 1",
+	} {
+		bytecode, err := wvm.AssembleText(text)
+		if err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		err = bytecode.Verify()
+		if !errors.Is(err, wvm.ErrInvalidCode) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %s", text, err, want)
+		}
+		if err := wvm.Exec(context.Background(), bytecode, nil, common.RunOptions{Stdout: io.Discard}); !errors.Is(err, wvm.ErrInvalidCode) {
+			t.Errorf("%q: ran with %v", text, err)
+		}
+	}
+}
+
+// code after a return or a break takes no slots in the frames and globals
+func TestWvmDeadCodeSlots(t *testing.T) {
+	bytecode, err := wvm.Compile(checkSource(t, `func f() int {
+    return 1;
+    var x = 2;
+}
+var i = 0;
+while i < 1 {
+    i = i + 1;
+    break;
+    var y = 3;
+}
+print f();
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytecode.Globals != 1 || bytecode.Functions[0].Locals != 0 {
+		t.Errorf("%d globals and %d locals of f, want 1 and 0", bytecode.Globals, bytecode.Functions[0].Locals)
+	}
+	var stdout bytes.Buffer
+	if err := wvm.Exec(context.Background(), bytecode, nil, common.RunOptions{Stdout: &stdout}); err != nil || stdout.String() != "1\n" {
+		t.Errorf("printed %q, %v", stdout.String(), err)
+	}
+}
+
+// calls take their frames and locals from stacks the WVM keeps
+func TestWvmCallsDontAllocate(t *testing.T) {
+	program := checkSource(t, `func fib(n int) int {
+    var a = n - 1;
+    var b float = float(n) - 2.0;
+    if n < 2 {
+        return n;
+    }
+    return fib(a) + fib(int(b));
+}
+`)
+	machine, err := wvm.NewMachine(context.Background(), program, common.RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func() {
+		if result, err := machine.Call(context.Background(), "fib", []interface{}{15}); err != nil || result != 610 {
+			t.Fatalf("fib(15) = %v, %v", result, err)
+		}
+	}
+	call()
+	// fib(15) makes close to 2000 calls
+	if allocs := testing.AllocsPerRun(10, call); allocs > 10 {
+		t.Errorf("%v allocations by fib(15)", allocs)
+	}
+}
+
+func BenchmarkWvmMandel(b *testing.B) {
+	benchmarkEngine(b, "23_mandel.wb", runWvm)
+}
+
+func BenchmarkWvmFib(b *testing.B) {
+	benchmarkEngine(b, "22_fib.wb", runWvm)
+}
@@ -0,0 +1,257 @@
+package tokenize
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+	"wabbit-go/model"
+)
+
+const chunkSize = 4096
+
+// Lexer scans tokens on demand. Source is read from the reader only as far as
+// the tokens asked for need it. Tokens handed out by Next are dropped unless
+// a Mark holds on to them.
+type Lexer struct {
+	file      string
+	reader    io.Reader
+	src       []byte // everything read so far, token offsets point into it
+	n         int    // scan position in src
+	lineno    int
+	lineStart int // offset of the first byte of the line being scanned
+
+	tokens []Token // scanned, not yet dropped
+	base   int     // number of tokens dropped before tokens[0]
+	next   int     // number of the token Next gives
+	marks  int
+
+	diagnostics model.Diagnostics
+	comments    []model.Comment
+}
+
+func NewLexer(file string, reader io.Reader) *Lexer {
+	return &Lexer{file: file, reader: reader, lineno: 1}
+}
+
+func NewStringLexer(file string, text string) *Lexer {
+	return &Lexer{file: file, src: []byte(text), lineno: 1}
+}
+
+// Next gives the next token, EOF over and over at the end
+func (l *Lexer) Next() Token {
+	tok := l.Peek(0)
+	if tok.Type != "EOF" {
+		l.next++
+	}
+	if l.marks == 0 {
+		// nobody can come back here any more
+		l.tokens = l.tokens[l.next-l.base:]
+		l.base = l.next
+	}
+	return tok
+}
+
+// Peek gives the token k places after the one Next gives, without consuming
+func (l *Lexer) Peek(k int) Token {
+	for l.next+k-l.base >= len(l.tokens) {
+		if n := len(l.tokens); n > 0 && l.tokens[n-1].Type == "EOF" {
+			return l.tokens[n-1]
+		}
+		l.tokens = append(l.tokens, l.scan())
+	}
+	return l.tokens[l.next+k-l.base]
+}
+
+// Mark remembers the current position for Reset or Release
+type Mark int
+
+func (l *Lexer) Mark() Mark {
+	l.marks++
+	return Mark(l.next)
+}
+
+// Reset backtracks to m and releases it
+func (l *Lexer) Reset(m Mark) {
+	l.next = int(m)
+	l.Release(m)
+}
+
+// Release gives up on m, tokens are dropped again once no mark is held
+func (l *Lexer) Release(m Mark) {
+	l.marks--
+}
+
+// Diagnostics gives the errors found in what was scanned so far
+func (l *Lexer) Diagnostics() model.Diagnostics {
+	return l.diagnostics
+}
+
+// Comments gives the comments skipped so far, in source order
+func (l *Lexer) Comments() []model.Comment {
+	return l.comments
+}
+
+// Source gives the text read so far
+func (l *Lexer) Source() string {
+	return string(l.src)
+}
+
+// at gives the byte at i, reading more of the source when needed
+func (l *Lexer) at(i int) (byte, bool) {
+	for i >= len(l.src) && l.reader != nil {
+		chunk := make([]byte, chunkSize)
+		n, err := l.reader.Read(chunk)
+		l.src = append(l.src, chunk[:n]...)
+		if err != nil {
+			l.reader = nil
+		}
+	}
+	if i >= len(l.src) {
+		return 0, false
+	}
+	return l.src[i], true
+}
+
+func (l *Lexer) hasPrefix(prefix string) bool {
+	for i := 0; i < len(prefix); i++ {
+		if c, ok := l.at(l.n + i); !ok || c != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// position of offset on the line being scanned
+func (l *Lexer) position(offset int) model.Position {
+	column := utf8.RuneCount(l.src[l.lineStart:offset]) + 1
+	return model.Position{Offset: offset, Line: l.lineno, Column: column}
+}
+
+func (l *Lexer) report(start model.Position, end int, code string, format string, args ...interface{}) {
+	loc := model.NewPositionLocator(l.file, string(l.src), start, l.position(end))
+	l.diagnostics = append(l.diagnostics, model.NewDiagnostic(loc, code, format, args...))
+}
+
+func (l *Lexer) token(typ string, start int) Token {
+	return Token{
+		Type:  typ,
+		Value: string(l.src[start:l.n]),
+		File:  l.file,
+		Pos:   l.position(start),
+		End:   l.position(l.n),
+	}
+}
+
+func (l *Lexer) comment(pos model.Position, start int) {
+	text := strings.TrimRight(string(l.src[start:l.n]), "\r")
+	l.comments = append(l.comments, model.Comment{Text: text, Pos: pos, End: l.position(start + len(text))})
+}
+
+func (l *Lexer) newline() {
+	l.n++
+	l.lineno++
+	l.lineStart = l.n
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+// scan reads one token, skipping blanks, comments and bad characters
+func (l *Lexer) scan() Token {
+	for {
+		c, ok := l.at(l.n)
+		if !ok {
+			pos := l.position(l.n)
+			return Token{Type: "EOF", Value: "EOF", File: l.file, Pos: pos, End: pos}
+		}
+		start := l.n
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			l.n++
+		case c == '\n':
+			l.newline()
+		case l.hasPrefix("/*"):
+			pos := l.position(start)
+			l.n += 2
+			for !l.hasPrefix("*/") {
+				c, ok := l.at(l.n)
+				if !ok {
+					l.report(pos, len(l.src), ErrUnterminatedComment, "unterminated comment")
+					break
+				}
+				if c == '\n' {
+					l.newline()
+				} else {
+					l.n++
+				}
+			}
+			if l.hasPrefix("*/") {
+				l.n += 2
+			}
+			l.comment(pos, start)
+		case l.hasPrefix("//"):
+			pos := l.position(start)
+			for c, ok := l.at(l.n); ok && c != '\n'; c, ok = l.at(l.n) {
+				l.n++
+			}
+			l.comment(pos, start)
+		case isDigit(c):
+			l.digits()
+			if c, _ := l.at(l.n); c == '.' {
+				l.n++
+				l.digits()
+				return l.token("FLOAT", start)
+			}
+			return l.token("INTEGER", start)
+		case isLetter(c):
+			for c, ok := l.at(l.n); ok && (isLetter(c) || isDigit(c)); c, ok = l.at(l.n) {
+				l.n++
+			}
+			tok := l.token("ID", start)
+			if keywords[tok.Value] {
+				tok.Type = strings.ToUpper(tok.Value)
+			}
+			return tok
+		case c == '\'':
+			l.n++
+			for c, ok := l.at(l.n); ok && c != '\'' && c != '\n'; c, ok = l.at(l.n) {
+				if next, _ := l.at(l.n + 1); c == '\\' && next != '\n' {
+					l.n++
+				}
+				l.n++
+			}
+			if c, ok := l.at(l.n); !ok || c != '\'' {
+				l.report(l.position(start), start+1, ErrUnterminatedChar, "unterminated character constant")
+				continue
+			}
+			l.n++
+			return l.token("CHAR", start)
+		default:
+			for _, size := range []int{2, 1} {
+				if _, ok := l.at(l.n + size - 1); !ok {
+					continue
+				}
+				if typ := literals[string(l.src[l.n:l.n+size])]; typ != "" {
+					l.n += size
+					return l.token(typ, start)
+				}
+			}
+			// report a multi byte character once
+			l.at(l.n + utf8.UTFMax - 1)
+			r, size := utf8.DecodeRune(l.src[l.n:])
+			l.n += size
+			l.report(l.position(start), l.n, ErrIllegalCharacter, "illegal character %q", r)
+		}
+	}
+}
+
+func (l *Lexer) digits() {
+	for c, ok := l.at(l.n); ok && isDigit(c); c, ok = l.at(l.n) {
+		l.n++
+	}
+}
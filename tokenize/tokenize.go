@@ -1,23 +1,35 @@
 package tokenize
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"strings"
-	"unicode"
+	"wabbit-go/model"
+)
+
+// error codes of the tokenizer
+const (
+	ErrUnterminatedComment = "E0001"
+	ErrUnterminatedChar    = "E0002"
+	ErrIllegalCharacter    = "E0003"
 )
 
 // Token structure
 type Token struct {
-	Type   string
-	Value  string
-	Lineno int
-	Index  int
+	Type  string
+	Value string
+	File  string
+	Pos   model.Position // first character
+	End   model.Position // just after the last one
 }
 
 func (t Token) String() string {
-	return fmt.Sprintf("Token(%s, %s, %d, %d)", t.Type, t.Value, t.Lineno, t.Index)
+	return fmt.Sprintf("Token(%s, %s, %s-%s)", t.Type, t.Value, t.Pos, t.End)
+}
+
+// Locator of the token in source
+func (t Token) Locator(source string) model.Locator {
+	return model.NewPositionLocator(t.File, source, t.Pos, t.End)
 }
 
 var literals = map[string]string{
@@ -43,128 +55,61 @@ var literals = map[string]string{
 	",":  "COMMA",
 }
 
-var keywords = map[string]bool{"print": true, "if": true, "else": true, "var": true, "const": true, "func": true, "while": true, "break": true, "continue": true, "return": true, "true": true, "false": true}
-
-func isAlnum(r rune) bool {
-	return unicode.IsLetter(r) || unicode.IsDigit(r)
+// Describe names a token type the way error messages talk about it
+func Describe(tokType string) string {
+	for text, typ := range literals {
+		if typ == tokType {
+			return fmt.Sprintf("'%s'", text)
+		}
+	}
+	switch tokType {
+	case "ID":
+		return "identifier"
+	case "INTEGER":
+		return "integer"
+	case "FLOAT":
+		return "float"
+	case "CHAR":
+		return "character"
+	case "EOF":
+		return "end of file"
+	}
+	return fmt.Sprintf("'%s'", strings.ToLower(tokType))
 }
 
+var keywords = map[string]bool{"print": true, "if": true, "else": true, "var": true, "const": true, "func": true, "while": true, "break": true, "continue": true, "return": true, "true": true, "false": true}
+
 // tokenize function
 func Tokenize(text string) ([]Token, error) {
-	var err error
+	return TokenizeFile("", text)
+}
+
+// TokenizeFile is Tokenize with errors reported against filename, they come back as model.Diagnostics
+func TokenizeFile(filename string, text string) ([]Token, error) {
+	return tokenizeAll(NewStringLexer(filename, text))
+}
+
+func tokenizeAll(lexer *Lexer) ([]Token, error) {
 	tokens := []Token{}
-	n := 0
-	lineno := 1
-	size := len(text)
-	error_array := []string{}
-	for n < size {
-		if text[n] == ' ' || text[n] == '\t' {
-			n++
-			continue
-		} else if text[n] == '\n' {
-			n++
-			lineno++
-			continue
-		} else if n+1 < size && text[n:n+2] == "/*" {
-			end := strings.Index(text[n:], "*/")
-			if end < 0 {
-				error_array = append(error_array, fmt.Sprintf("on line %d: Unterminated comment", lineno))
-				n = size
-				continue
-			} else {
-				lineno += strings.Count(text[n:n+end], "\n")
-				n = n + end + 2
-				continue
-			}
-		} else if n+1 < size && text[n:n+2] == "//" {
-			end := strings.Index(text[n:], "\n")
-			if end < 0 {
-				break
-			}
-			lineno++
-			n = n + end + 1
-			continue
-		} else if unicode.IsDigit(rune(text[n])) {
-			start := n
-			for n < size && unicode.IsDigit(rune(text[n])) {
-				n++
-			}
-			if n < size && text[n] == '.' {
-				n++
-				for n < size && unicode.IsDigit(rune(text[n])) {
-					n++
-				}
-				tokens = append(tokens, Token{Type: "FLOAT", Value: text[start:n], Lineno: lineno, Index: start})
-			} else {
-				tokens = append(tokens, Token{Type: "INTEGER", Value: text[start:n], Lineno: lineno, Index: start})
-			}
-			continue
-		} else if unicode.IsLetter(rune(text[n])) || text[n] == '_' {
-			start := n
-			for n < size && (isAlnum(rune(text[n])) || text[n] == '_') {
-				n++
-			}
-			tokType := text[start:n]
-			if keywords[tokType] {
-				tokens = append(tokens, Token{Type: strings.ToUpper(tokType), Value: tokType, Lineno: lineno, Index: start})
-			} else {
-				tokens = append(tokens, Token{Type: "ID", Value: tokType, Lineno: lineno, Index: start})
-			}
-			continue
-		} else if text[n] == '\'' {
-			start := n
-			n++
-			for n < size && text[n] != '\'' {
-				if text[n] == '\\' {
-					n++
-				}
-				n++
-			}
-			if n >= size {
-				error_array = append(error_array, fmt.Sprintf("on line %d: Unterminated character constant", lineno))
-			} else {
-				tokens = append(tokens, Token{Type: "CHAR", Value: text[start : n+1], Lineno: lineno, Index: start})
-				n++
-			}
-			continue
-		} else if n+1 < size && literals[text[n:n+2]] != "" {
-			tokens = append(tokens, Token{Type: literals[text[n:n+2]], Value: text[n : n+2], Lineno: lineno, Index: n})
-			n += 2
-			continue
-		} else if literals[string(text[n])] != "" {
-			tokens = append(tokens, Token{Type: literals[string(text[n])], Value: string(text[n]), Lineno: lineno, Index: n})
-			n++
-			continue
-		} else {
-			error_array = append(error_array, fmt.Sprintf("on line %d: Illegal character %s", lineno, string(text[n])))
-			n++
+	for {
+		tok := lexer.Next()
+		tokens = append(tokens, tok)
+		if tok.Type == "EOF" {
+			break
 		}
 	}
-	tokens = append(tokens, Token{Type: "EOF", Value: "EOF", Lineno: lineno, Index: n})
-	if len(error_array) > 0 {
-		err = fmt.Errorf("error number %d\n error details :\n %s", len(error_array), strings.Join(error_array, "\n"))
+	if diagnostics := lexer.Diagnostics(); len(diagnostics) > 0 {
+		return tokens, diagnostics
 	}
-	return tokens, err
+	return tokens, nil
 }
 
 // main function to test on input files
 func HandleFile(filename string) ([]Token, error) {
-	file, err := os.Open(filename) // change filename to your file
+	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
 	}
 	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	scanner.Split(bufio.ScanLines)
-
-	var text string
-
-	for scanner.Scan() {
-		text += scanner.Text() + "\n"
-	}
-
-	return Tokenize(text)
-
+	return tokenizeAll(NewLexer(filename, file))
 }
@@ -51,6 +51,7 @@ func (f *Function) String() string {
 }
 
 type Context struct {
+	program  *model.Program
 	module   []string
 	env      *common.ChainMap
 	function Function
@@ -64,10 +65,11 @@ type WASMVar struct {
 	Scope string
 }
 
-func NewWabbitWasmModule() *Context {
+func NewWabbitWasmModule(program *model.Program) *Context {
 	w := &Context{
-		module: []string{"(module"},
-		env:    common.NewChainMap(),
+		program: program,
+		module:  []string{"(module"},
+		env:     common.NewChainMap(),
 		function: Function{
 			name: "main",
 		},
@@ -77,6 +79,15 @@ func NewWabbitWasmModule() *Context {
 	w.module = append(w.module, "(import \"env\" \"_printf\" (func $_printf ( param f64 )))")
 	w.module = append(w.module, "(import \"env\" \"_printb\" (func $_printb ( param i32 )))")
 	w.module = append(w.module, "(import \"env\" \"_printc\" (func $_printc ( param i32 )))")
+	// the host gives its functions in env too
+	for _, f := range program.Host.Functions() {
+		signature := ""
+		for _, param := range f.Params {
+			signature += fmt.Sprintf(" ( param %s )", _typemap[param])
+		}
+		w.module = append(w.module, fmt.Sprintf("(import \"env\" \"%s\" (func $%s%s ( result %s )))",
+			f.Name, f.Name, signature, _typemap[f.Result]))
+	}
 
 	return w
 }
@@ -85,6 +96,11 @@ func (m *Context) String() string {
 	return strings.Join(m.module, "\n") + "\n)\n"
 }
 
+// TypeOf gives the type the checker recorded for node
+func (ctx *Context) TypeOf(node model.Node) string {
+	return ctx.program.TypeOf(node)
+}
+
 func (ctx *Context) Define(name string, value *WASMVar) {
 	ctx.env.SetValue(name, value)
 }
@@ -120,7 +136,7 @@ func (m *Context) NewLabel(name ...string) string {
 }
 
 func Wasm(program *model.Program) string {
-	wctx := NewWabbitWasmModule()
+	wctx := NewWabbitWasmModule(program)
 	_ = InterpretNode(program.Model, wctx) // generate is InterpretNode in the same meaning
 	// where
 	wctx.module = append(wctx.module, wctx.function.String())
@@ -176,111 +192,71 @@ func InterpretNode(node model.Node, context *Context) string {
 	//	//return &WabbitValue{Type: "type", Value: "float"}
 	//	return "float"
 	case *model.Add:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.function.code = append(context.function.code, "i32.add")
-			return "int"
-			//return &WabbitValue{"int", left.Value.(int) + right.Value.(int)}
-		} else if left == "float" && right == "float" {
-			//return &WabbitValue{"float", left.Value.(float64) + right.Value.(float64)}
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code, "f64.add")
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
-			//return &WabbitValue{"error", "type error"}
+			context.function.code = append(context.function.code, "i32.add")
 		}
-		return left
+		return typ
 	case *model.Mul:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.function.code = append(context.function.code, "i32.mul")
-			return "int"
-			//return &WabbitValue{"int", left.Value.(int) * right.Value.(int)}
-		} else if left == "float" && right == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code, "f64.mul")
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
+			context.function.code = append(context.function.code, "i32.mul")
 		}
-		return left
+		return typ
 	case *model.Sub:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.function.code = append(context.function.code, "i32.sub")
-			return "int"
-			//return &WabbitValue{"int", left.Value.(int) - right.Value.(int)}
-		} else if left == "float" && right == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code, "f64.sub")
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
+			context.function.code = append(context.function.code, "i32.sub")
 		}
-		return left
+		return typ
 	case *model.Div:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.function.code = append(context.function.code, "i32.div_s")
-			return "int"
-		} else if left == "float" && right == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = append(context.function.code, "f64.div")
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
+			context.function.code = append(context.function.code, "i32.div_s")
 		}
-		return left
+		return typ
 
 	case *model.Neg:
 		pos := len(context.function.code)
-		right := InterpretNode(v.Operand, context)
-		if right == "int" {
-			context.function.code = insert(context.function.code, "i32.const 0", pos)
-			context.function.code = append(context.function.code, "i32.sub")
-		} else if right == "float" {
-			//return &WabbitValue{"float", -right.Value.(float64)}
+		InterpretNode(v.Operand, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.function.code = insert(context.function.code, "f64.const 0", pos)
 			context.function.code = append(context.function.code, "f64.sub")
 		} else {
-			// we think it's a type error
-			//return &WabbitValue{"error", "type error"}
-			panic("type different")
+			context.function.code = insert(context.function.code, "i32.const 0", pos)
+			context.function.code = append(context.function.code, "i32.sub")
 		}
-		return right
+		return typ
 	case *model.Pos:
 		right := InterpretNode(v.Operand, context)
 		return right
 	case *model.Not:
-		right := InterpretNode(v.Operand, context)
-		if right == "bool" {
-			context.function.code = append(context.function.code, "i32.const 1")
-			context.function.code = append(context.function.code, "i32.xor")
-		} else {
-			// we think it's a type error
-			panic("type different")
-		}
-		return right
+		InterpretNode(v.Operand, context)
+		context.function.code = append(context.function.code, "i32.const 1")
+		context.function.code = append(context.function.code, "i32.xor")
+		return "bool"
 	case *model.VarDeclaration:
 		//var val *WVMVar
-		var valtype string
+		valtype := context.TypeOf(v)
 		if v.Value != nil {
-			valtype = InterpretNode(v.Value, context) // store in stack
-		} else {
-			valtype = v.Type.Type()
+			InterpretNode(v.Value, context) // store in stack
 		}
 
 		if context.scope == "global" {
@@ -308,7 +284,8 @@ func InterpretNode(node model.Node, context *Context) string {
 		return ""
 
 	case *model.ConstDeclaration:
-		valtype := InterpretNode(v.Value, context)
+		InterpretNode(v.Value, context)
+		valtype := context.TypeOf(v)
 		if context.scope == "global" {
 			if valtype == "float" {
 				context.module = append(context.module, fmt.Sprintf("(global $%s (mut f64) (f64.const 0.0))", v.Name.Text))
@@ -328,9 +305,9 @@ func InterpretNode(node model.Node, context *Context) string {
 		return ""
 
 	case *model.Lt:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, "f64.lt")
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -338,9 +315,9 @@ func InterpretNode(node model.Node, context *Context) string {
 		}
 		return "bool"
 	case *model.Le:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, "f64.le")
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -348,9 +325,9 @@ func InterpretNode(node model.Node, context *Context) string {
 		}
 		return "bool"
 	case *model.Gt:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, "f64.gt")
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -358,9 +335,9 @@ func InterpretNode(node model.Node, context *Context) string {
 		}
 		return "bool"
 	case *model.Ge:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, "f64.ge")
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -368,9 +345,9 @@ func InterpretNode(node model.Node, context *Context) string {
 		}
 		return "bool"
 	case *model.Eq:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, "f64.eq")
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -378,9 +355,9 @@ func InterpretNode(node model.Node, context *Context) string {
 		}
 		return "bool"
 	case *model.Ne:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.function.code = append(context.function.code, "f64.ne")
 		} else {
 			//return &WabbitValue{Type: "bool", Value: left.Value.(int) < right.Value.(int)}
@@ -435,8 +412,8 @@ func InterpretNode(node model.Node, context *Context) string {
 		return val
 
 	case *model.PrintStatement:
-		value := InterpretNode(v.Value, context)
-		switch value {
+		InterpretNode(v.Value, context)
+		switch context.TypeOf(v.Value) {
 		case "char":
 			context.function.code = append(context.function.code, "call $_printc")
 		case "bool":
@@ -549,9 +526,10 @@ func InterpretNode(node model.Node, context *Context) string {
 		}
 
 	case *model.FunctionApplication:
-		argType := "int"
+		argType := ""
 		for _, arg := range v.Arguments {
-			argType = InterpretNode(arg, context) // arg eval in current context
+			InterpretNode(arg, context) // arg eval in current context
+			argType = context.TypeOf(arg)
 		}
 		name := v.Func.(*model.Name).Text
 		log.Debugf("name %v", name)
@@ -586,8 +564,7 @@ func InterpretNode(node model.Node, context *Context) string {
 		//	context.function.code = append(context.function.code, fmt.Sprintf("call $%s", name))
 		//}
 		context.function.code = append(context.function.code, fmt.Sprintf("call $%s", name))
-		val := context.Lookup(name)
-		return val.Type
+		return context.TypeOf(v)
 		// custom function and it should be....
 
 	case *model.CompoundExpression:
@@ -0,0 +1,267 @@
+package wvm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"wabbit-go/model"
+)
+
+// Opcode is the first byte of an instruction of the bytecode, its operand
+// follows inline
+type Opcode byte
+
+const (
+	IPUSH Opcode = iota // int64
+	IPOP
+	IDUP
+	IADD
+	ISUB
+	IMUL
+	IDIV
+	INEG
+	ICMP // comparison
+	AND
+	OR
+	XOR
+	FPUSH // float64
+	FPOP
+	FDUP
+	FADD
+	FSUB
+	FMUL
+	FDIV
+	FNEG
+	FCMP // comparison
+	ITOF
+	FTOI
+	PRINTI
+	PRINTF
+	PRINTB
+	PRINTC
+	ILOAD_LOCAL   // slot
+	ISTORE_LOCAL  // slot
+	FLOAD_LOCAL   // slot
+	FSTORE_LOCAL  // slot
+	ILOAD_GLOBAL  // slot
+	ISTORE_GLOBAL // slot
+	FLOAD_GLOBAL  // slot
+	FSTORE_GLOBAL // slot
+	GOTO          // offset
+	BZ            // offset
+	CALL          // offset
+	TAIL_CALL     // offset
+	RETURN
+	HOST_CALL // index of the name in Bytecode.Hosts
+	HALT
+	numOpcodes
+)
+
+var opcodeNames = [numOpcodes]string{
+	"IPUSH", "IPOP", "IDUP", "IADD", "ISUB", "IMUL", "IDIV", "INEG", "ICMP", "AND", "OR", "XOR",
+	"FPUSH", "FPOP", "FDUP", "FADD", "FSUB", "FMUL", "FDIV", "FNEG", "FCMP", "ITOF", "FTOI",
+	"PRINTI", "PRINTF", "PRINTB", "PRINTC",
+	"ILOAD_LOCAL", "ISTORE_LOCAL", "FLOAD_LOCAL", "FSTORE_LOCAL",
+	"ILOAD_GLOBAL", "ISTORE_GLOBAL", "FLOAD_GLOBAL", "FSTORE_GLOBAL",
+	"GOTO", "BZ", "CALL", "TAIL_CALL", "RETURN", "HOST_CALL", "HALT",
+}
+
+func (op Opcode) String() string {
+	if op < numOpcodes {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("opcode(%d)", byte(op))
+}
+
+// opcodes gives the opcode of an instruction name
+var opcodes = map[string]Opcode{}
+
+func init() {
+	for op, name := range opcodeNames {
+		opcodes[name] = Opcode(op)
+	}
+}
+
+// operand is the kind of operand following an opcode
+type operand int
+
+const (
+	noOperand operand = iota
+	intOperand
+	floatOperand
+	u32Operand // a slot, an offset or an index, 4 bytes
+	cmpOperand // a comparison, 1 byte
+)
+
+var operands = [numOpcodes]operand{
+	IPUSH: intOperand, FPUSH: floatOperand, ICMP: cmpOperand, FCMP: cmpOperand,
+	ILOAD_LOCAL: u32Operand, ISTORE_LOCAL: u32Operand, FLOAD_LOCAL: u32Operand, FSTORE_LOCAL: u32Operand,
+	ILOAD_GLOBAL: u32Operand, ISTORE_GLOBAL: u32Operand, FLOAD_GLOBAL: u32Operand, FSTORE_GLOBAL: u32Operand,
+	GOTO: u32Operand, BZ: u32Operand, CALL: u32Operand, TAIL_CALL: u32Operand, HOST_CALL: u32Operand,
+}
+
+// size gives the bytes taken by an instruction with opcode op
+func (op Opcode) size() int {
+	switch operands[op] {
+	case intOperand, floatOperand:
+		return 9
+	case u32Operand:
+		return 5
+	case cmpOperand:
+		return 2
+	}
+	return 1
+}
+
+// the comparisons of ICMP and FCMP
+const (
+	cmpLt byte = iota
+	cmpLe
+	cmpGt
+	cmpGe
+	cmpEq
+	cmpNe
+)
+
+var comparisons = []string{"<", "<=", ">", ">=", "==", "!="}
+
+// Func is an entry of the function table of bytecode
+type Func struct {
+	Name   string
+	Entry  int      // offset of its first instruction
+	Params []string // types
+	Result string
+	Locals int // slots of its frame, the parameters first
+}
+
+// MaxSlots is the most slots the globals, or the locals of a function, can
+// take. Loaded code is kept to it, the WVM makes room for all of them.
+const MaxSlots = 1 << 16
+
+// Signature gives f the way listings show it, fib(int) int
+func (f *Func) Signature() string {
+	return fmt.Sprintf("%s(%s) %s", f.Name, strings.Join(f.Params, ", "), f.Result)
+}
+
+// Bytecode is assembled WVM code. Operands are little endian.
+type Bytecode struct {
+	Code      []byte
+	Functions []*Func  // in the order of their entries
+	Hosts     []string // signatures of the host functions called
+	Globals   int      // slots of the globals
+	File      string   // the source compiled
+	starts    []int    // offset of every instruction
+	locs      []model.Locator
+	entries   map[int]*Func // functions by their entry
+	labels    map[int]int   // offsets of the labels
+}
+
+// Assemble turns code into bytecode, labels become the offsets of the
+// instructions following them. locs are where each instruction of code
+// comes from, nil when not known, and functions the functions by their
+// start label, Assemble sets their entries.
+func Assemble(code []Instruction, locs []model.Locator, functions map[int]*Func) (*Bytecode, error) {
+	bc := &Bytecode{labels: map[int]int{}}
+	// the offsets of the labels first, jumps can go forward
+	offset := 0
+	for _, instruction := range code {
+		if instruction.opcode == "LABEL" {
+			bc.labels[instruction.args.(int)] = offset
+			continue
+		}
+		op, ok := opcodes[instruction.opcode]
+		if !ok {
+			return nil, fmt.Errorf("no such opcode %v", instruction.opcode)
+		}
+		offset += op.size()
+	}
+	for label, f := range functions {
+		entry, ok := bc.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("no label %v for function '%s'", label, f.Name)
+		}
+		f.Entry = entry
+		bc.Functions = append(bc.Functions, f)
+	}
+	bc.index()
+	bc.Code = make([]byte, 0, offset)
+	hosts := map[string]int{}
+	for i, instruction := range code {
+		if instruction.opcode == "LABEL" {
+			continue
+		}
+		op := opcodes[instruction.opcode]
+		bc.starts = append(bc.starts, len(bc.Code))
+		if locs != nil {
+			bc.locs = append(bc.locs, locs[i])
+		}
+		bc.Code = append(bc.Code, byte(op))
+		switch operands[op] {
+		case intOperand:
+			bc.Code = binary.LittleEndian.AppendUint64(bc.Code, uint64(instruction.args.(int)))
+		case floatOperand:
+			bc.Code = binary.LittleEndian.AppendUint64(bc.Code, math.Float64bits(instruction.args.(float64)))
+		case cmpOperand:
+			cmp := indexOf(comparisons, instruction.args.(string))
+			if cmp < 0 {
+				return nil, fmt.Errorf("no such comparison %v", instruction.args)
+			}
+			bc.Code = append(bc.Code, byte(cmp))
+		case u32Operand:
+			var n int
+			switch op {
+			case GOTO, BZ, CALL, TAIL_CALL:
+				target, ok := bc.labels[instruction.args.(int)]
+				if !ok {
+					return nil, fmt.Errorf("no label %v", instruction.args)
+				}
+				n = target
+			case HOST_CALL:
+				signature := instruction.args.(string)
+				if _, ok := hosts[signature]; !ok {
+					hosts[signature] = len(bc.Hosts)
+					bc.Hosts = append(bc.Hosts, signature)
+				}
+				n = hosts[signature]
+			default:
+				n = instruction.args.(int)
+			}
+			bc.Code = binary.LittleEndian.AppendUint32(bc.Code, uint32(n))
+		}
+	}
+	return bc, nil
+}
+
+// index sorts the functions by entry and indexes them
+func (bc *Bytecode) index() {
+	sort.Slice(bc.Functions, func(i, j int) bool { return bc.Functions[i].Entry < bc.Functions[j].Entry })
+	bc.entries = make(map[int]*Func, len(bc.Functions))
+	for _, f := range bc.Functions {
+		bc.entries[f.Entry] = f
+	}
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// instruction gives the index of the instruction at offset, the one it is in
+func (bc *Bytecode) instruction(offset int) int {
+	return sort.SearchInts(bc.starts, offset+1) - 1
+}
+
+// location gives where the instruction at offset, the one it is in, comes from
+func (bc *Bytecode) location(offset int) model.Locator {
+	i := bc.instruction(offset)
+	if i < 0 || i >= len(bc.locs) {
+		return model.Locator{}
+	}
+	return bc.locs[i]
+}
@@ -0,0 +1,270 @@
+package wvm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"wabbit-go/model"
+)
+
+// Listings are the text form of bytecode, Disassemble writes them and
+// AssembleText reads them back:
+//
+//	.file fib.wb
+//	.globals 1
+//	        0  GOTO .L1
+//
+//	.func fib(int) int locals 1
+//	        5  ISTORE_LOCAL 0            ; 1 | func fib(n int) int {
+//	       10  ILOAD_LOCAL 0             ; 2 | if n < 2 {
+//	...
+//	.L1:
+//	       83  IPUSH 30
+//	       92  CALL fib
+//
+// A .func starts a function, its name is the label of its entry, the other
+// labels jumped to are .L0, .L1 and so on. The offsets in front of the
+// instructions and everything after a ; are left out by AssembleText.
+
+// Disassemble gives the listing of bc, annotated with the lines of source
+// when it is the source bc was compiled from
+func Disassemble(bc *Bytecode, source string) string {
+	var sources []string
+	if source != "" {
+		sources = strings.Split(source, "\n")
+	}
+	labels := bc.labelNames()
+	var sb strings.Builder
+	if bc.File != "" {
+		fmt.Fprintf(&sb, ".file %s\n", bc.File)
+	}
+	fmt.Fprintf(&sb, ".globals %d\n", bc.Globals)
+	last := 0 // line annotated last
+	for _, offset := range bc.starts {
+		if f := bc.entries[offset]; f != nil {
+			fmt.Fprintf(&sb, "\n.func %s locals %d\n", f.Signature(), f.Locals)
+		} else if label, ok := labels[offset]; ok {
+			fmt.Fprintf(&sb, "%s:\n", label)
+		}
+		text := fmt.Sprintf("%9d  %s", offset, bc.disassemble(offset, labels))
+		if line := bc.location(offset).StartPos.Line; line != 0 && line != last {
+			last = line
+			text = fmt.Sprintf("%-40s ; %d", text, line)
+			if line <= len(sources) {
+				text += " | " + strings.TrimSpace(sources[line-1])
+			}
+		}
+		sb.WriteString(text + "\n")
+	}
+	return sb.String()
+}
+
+// labelNames names the offsets jumped to, functions by their names
+func (bc *Bytecode) labelNames() map[int]string {
+	var targets []int
+	for _, offset := range bc.starts {
+		switch Opcode(bc.Code[offset]) {
+		case GOTO, BZ, CALL, TAIL_CALL:
+			targets = append(targets, int(binary.LittleEndian.Uint32(bc.Code[offset+1:])))
+		}
+	}
+	sort.Ints(targets)
+	labels := map[int]string{}
+	for _, f := range bc.Functions {
+		labels[f.Entry] = f.Name
+	}
+	for _, target := range targets {
+		if _, ok := labels[target]; !ok {
+			labels[target] = fmt.Sprintf(".L%d", len(labels)-len(bc.Functions))
+		}
+	}
+	return labels
+}
+
+// disassemble gives the instruction at offset as text
+func (bc *Bytecode) disassemble(offset int, labels map[int]string) string {
+	op := Opcode(bc.Code[offset])
+	operand := bc.Code[offset+1 : offset+op.size()]
+	switch operands[op] {
+	case intOperand:
+		return fmt.Sprintf("%v %d", op, int64(binary.LittleEndian.Uint64(operand)))
+	case floatOperand:
+		return fmt.Sprintf("%v %s", op, strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(operand)), 'g', -1, 64))
+	case cmpOperand:
+		if int(operand[0]) < len(comparisons) {
+			return fmt.Sprintf("%v %s", op, comparisons[operand[0]])
+		}
+		return fmt.Sprintf("%v comparison(%d)", op, operand[0])
+	case u32Operand:
+		n := int(binary.LittleEndian.Uint32(operand))
+		switch op {
+		case GOTO, BZ, CALL, TAIL_CALL:
+			if label, ok := labels[n]; ok {
+				return fmt.Sprintf("%v %s", op, label)
+			}
+		case HOST_CALL:
+			if n < len(bc.Hosts) {
+				return fmt.Sprintf("%v %s", op, bc.Hosts[n])
+			}
+		}
+		return fmt.Sprintf("%v %d", op, n)
+	}
+	return op.String()
+}
+
+// assembler reads a listing into instructions for Assemble
+type assembler struct {
+	code      []Instruction
+	functions map[int]*Func // by label
+	labels    map[string]int
+	defined   map[string]bool
+	used      map[string]int // line of the first jump to a label
+	lineno    int            // of the line read
+}
+
+// label gives the number of the label name
+func (a *assembler) label(name string) int {
+	if n, ok := a.labels[name]; ok {
+		return n
+	}
+	a.labels[name] = len(a.labels)
+	return a.labels[name]
+}
+
+func (a *assembler) define(name string) error {
+	if a.defined[name] {
+		return fmt.Errorf("label %s defined twice", name)
+	}
+	a.defined[name] = true
+	a.code = append(a.code, Instruction{"LABEL", a.label(name)})
+	return nil
+}
+
+// AssembleText gives the bytecode of a listing, see Disassemble
+func AssembleText(text string) (*Bytecode, error) {
+	a := &assembler{functions: map[int]*Func{}, labels: map[string]int{}, defined: map[string]bool{}, used: map[string]int{}}
+	var file string
+	globals := 0
+	for i, line := range strings.Split(text, "\n") {
+		a.lineno = i + 1
+		if err := a.line(line, &file, &globals); err != nil {
+			return nil, fmt.Errorf("line %d: %v", a.lineno, err)
+		}
+	}
+	for name, line := range a.used {
+		if !a.defined[name] {
+			return nil, fmt.Errorf("line %d: no label %s", line, name)
+		}
+	}
+	bc, err := Assemble(a.code, nil, a.functions)
+	if err != nil {
+		return nil, err
+	}
+	bc.File, bc.Globals = file, globals
+	return bc, nil
+}
+
+func (a *assembler) line(line string, file *string, globals *int) (err error) {
+	if comment := strings.IndexByte(line, ';'); comment >= 0 {
+		line = line[:comment]
+	}
+	line = strings.TrimSpace(line)
+	directive, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	switch {
+	case line == "":
+	case directive == ".file":
+		*file = rest
+	case directive == ".globals":
+		*globals, err = count(rest)
+	case directive == ".func":
+		signature, locals, ok := strings.Cut(rest, " locals ")
+		if !ok {
+			return fmt.Errorf("want .func name(types) type locals n")
+		}
+		f, err := model.ParseSignature(signature)
+		if err != nil {
+			return err
+		}
+		fn := &Func{Name: f.Name, Params: f.Params, Result: f.Result}
+		if fn.Locals, err = count(locals); err != nil {
+			return err
+		}
+		if err := a.define(fn.Name); err != nil {
+			return err
+		}
+		a.functions[a.label(fn.Name)] = fn
+	case strings.HasSuffix(line, ":") && !strings.Contains(line, " "):
+		return a.define(strings.TrimSuffix(line, ":"))
+	default:
+		return a.instruction(line)
+	}
+	return err
+}
+
+// instruction reads an instruction, after the offset it may start with
+func (a *assembler) instruction(line string) error {
+	fields := strings.Fields(line)
+	if _, err := strconv.Atoi(fields[0]); err == nil {
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return fmt.Errorf("no instruction")
+		}
+	}
+	op, ok := opcodes[fields[0]]
+	if !ok {
+		return fmt.Errorf("no such opcode %s", fields[0])
+	}
+	operand := strings.Join(fields[1:], " ")
+	if (operand == "") != (operands[op] == noOperand) {
+		if operand == "" {
+			return fmt.Errorf("%v needs an operand", op)
+		}
+		return fmt.Errorf("%v takes no operand", op)
+	}
+	var args interface{}
+	var err error
+	switch operands[op] {
+	case intOperand:
+		var n int64
+		n, err = strconv.ParseInt(operand, 10, 64)
+		args = int(n)
+	case floatOperand:
+		args, err = strconv.ParseFloat(operand, 64)
+	case cmpOperand:
+		if indexOf(comparisons, operand) < 0 {
+			return fmt.Errorf("no such comparison %s", operand)
+		}
+		args = operand
+	case u32Operand:
+		switch op {
+		case GOTO, BZ, CALL, TAIL_CALL:
+			if _, ok := a.used[operand]; !ok {
+				a.used[operand] = a.lineno
+			}
+			args = a.label(operand)
+		case HOST_CALL:
+			var f *model.HostFunction
+			f, err = model.ParseSignature(operand)
+			if err == nil {
+				args = f.Signature()
+			}
+		default:
+			args, err = count(operand)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("bad operand of %v: %v", op, err)
+	}
+	a.code = append(a.code, Instruction{op.String(), args})
+	return nil
+}
+
+// count reads a slot or a number of slots
+func count(text string) (int, error) {
+	n, err := strconv.ParseUint(strings.TrimSpace(text), 10, 32)
+	return int(n), err
+}
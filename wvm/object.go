@@ -0,0 +1,319 @@
+package wvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"wabbit-go/model"
+)
+
+// Object files, .wbc, keep bytecode to run it without the parser. Integers
+// are little endian u32 unless said otherwise, names and types are indices
+// into the constant pool.
+//
+//	header     "WBC\x00", version u16, flags u16
+//	pool       count, then every string as its length and bytes
+//	module     file, globals
+//	functions  count, then name, entry, params count and types, result, locals
+//	hosts      count, then signature
+//	code       length, then the bytes
+//	lines      with flagLines: count, then offset, start, end, line, column,
+//	           end line and end column of every run of instructions
+//	checksum   CRC-32 (IEEE) of everything before
+const (
+	objectMagic   = "WBC\x00"
+	ObjectVersion = 1
+)
+
+// the flags of the header
+const (
+	flagLines = 1 << iota // a line table follows the code
+)
+
+var (
+	// ErrObjectVersion is the error of an object file of another version
+	ErrObjectVersion = errors.New("unsupported object file version")
+	// ErrCorruptObject is the error of a file that isn't a valid object file
+	ErrCorruptObject = errors.New("corrupt object file")
+)
+
+// line is an entry of the line table, the instructions from offset to the
+// next entry come from loc
+type line struct {
+	offset int
+	loc    model.Locator
+}
+
+// lines gives the line table of bc, one entry per run of instructions of
+// the same location
+func (bc *Bytecode) lines() []line {
+	var lines []line
+	for i, loc := range bc.locs {
+		if len(lines) > 0 && samePlace(lines[len(lines)-1].loc, loc) {
+			continue
+		}
+		lines = append(lines, line{bc.starts[i], loc})
+	}
+	return lines
+}
+
+func samePlace(a, b model.Locator) bool {
+	return a.Start == b.Start && a.End == b.End && a.StartPos == b.StartPos && a.EndPos == b.EndPos
+}
+
+// objectWriter builds an object file, strings go to its pool
+type objectWriter struct {
+	buf     []byte
+	pool    []string
+	indices map[string]int
+}
+
+func (w *objectWriter) u32(n int) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(n))
+}
+
+func (w *objectWriter) str(s string) {
+	i, ok := w.indices[s]
+	if !ok {
+		i = len(w.pool)
+		w.indices[s] = i
+		w.pool = append(w.pool, s)
+	}
+	w.u32(i)
+}
+
+// Strip drops the locations of the instructions, the object file of bc has
+// no line table then and its runtime errors no lines
+func (bc *Bytecode) Strip() {
+	bc.locs = nil
+}
+
+// MarshalBinary gives bc as an object file
+func (bc *Bytecode) MarshalBinary() ([]byte, error) {
+	// the sections after the pool first, they fill it
+	w := &objectWriter{indices: map[string]int{}}
+	w.str(bc.File)
+	w.u32(bc.Globals)
+	w.u32(len(bc.Functions))
+	for _, f := range bc.Functions {
+		w.str(f.Name)
+		w.u32(f.Entry)
+		w.u32(len(f.Params))
+		for _, param := range f.Params {
+			w.str(param)
+		}
+		w.str(f.Result)
+		w.u32(f.Locals)
+	}
+	w.u32(len(bc.Hosts))
+	for _, signature := range bc.Hosts {
+		w.str(signature)
+	}
+	w.u32(len(bc.Code))
+	w.buf = append(w.buf, bc.Code...)
+	flags := 0
+	if bc.locs != nil {
+		flags |= flagLines
+		lines := bc.lines()
+		w.u32(len(lines))
+		for _, l := range lines {
+			for _, n := range []int{l.offset, l.loc.Start, l.loc.End, l.loc.StartPos.Line, l.loc.StartPos.Column, l.loc.EndPos.Line, l.loc.EndPos.Column} {
+				w.u32(n)
+			}
+		}
+	}
+
+	out := []byte(objectMagic)
+	out = binary.LittleEndian.AppendUint16(out, ObjectVersion)
+	out = binary.LittleEndian.AppendUint16(out, uint16(flags))
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(w.pool)))
+	for _, s := range w.pool {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(s)))
+		out = append(out, s...)
+	}
+	out = append(out, w.buf...)
+	return binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(out)), nil
+}
+
+// objectReader reads an object file, the first error stops it
+type objectReader struct {
+	data []byte
+	pos  int
+	pool []string
+	err  error
+}
+
+func (r *objectReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s", ErrCorruptObject, fmt.Sprintf(format, args...))
+	}
+}
+
+func (r *objectReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || n > len(r.data)-r.pos {
+		r.fail("truncated at byte %d", r.pos)
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *objectReader) u32() int {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(b))
+}
+
+// count reads the count of items of at least size bytes each
+func (r *objectReader) count(size int) int {
+	n := r.u32()
+	if n*size > len(r.data)-r.pos {
+		r.fail("%d items at byte %d, past the end", n, r.pos)
+		return 0
+	}
+	return n
+}
+
+func (r *objectReader) str() string {
+	i := r.u32()
+	if i >= len(r.pool) {
+		r.fail("no constant %d", i)
+		return ""
+	}
+	return r.pool[i]
+}
+
+// ReadObject loads the bytecode of an object file. Files of another version
+// give ErrObjectVersion, files that aren't valid ErrCorruptObject.
+func ReadObject(data []byte) (*Bytecode, error) {
+	if len(data) < len(objectMagic)+4 || string(data[:len(objectMagic)]) != objectMagic {
+		return nil, fmt.Errorf("%w: not a wabbit object file", ErrCorruptObject)
+	}
+	if version := binary.LittleEndian.Uint16(data[4:]); version != ObjectVersion {
+		return nil, fmt.Errorf("%w %d, want %d", ErrObjectVersion, version, ObjectVersion)
+	}
+	if len(data) < len(objectMagic)+8 {
+		return nil, fmt.Errorf("%w: truncated", ErrCorruptObject)
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorruptObject)
+	}
+	flags := binary.LittleEndian.Uint16(data[6:])
+	r := &objectReader{data: body, pos: 8}
+	for i, n := 0, r.count(4); i < n; i++ {
+		r.pool = append(r.pool, string(r.bytes(r.u32())))
+	}
+
+	bc := &Bytecode{File: r.str(), Globals: r.u32()}
+	for i, n := 0, r.count(20); i < n; i++ {
+		f := &Func{Name: r.str(), Entry: r.u32()}
+		for j, m := 0, r.count(4); j < m; j++ {
+			f.Params = append(f.Params, r.str())
+		}
+		f.Result, f.Locals = r.str(), r.u32()
+		bc.Functions = append(bc.Functions, f)
+	}
+	for i, n := 0, r.count(4); i < n; i++ {
+		bc.Hosts = append(bc.Hosts, r.str())
+	}
+	bc.Code = bytes.Clone(r.bytes(r.count(1)))
+	var lines []line
+	if flags&flagLines != 0 {
+		for i, n := 0, r.count(28); i < n; i++ {
+			l := line{offset: r.u32()}
+			l.loc.File = bc.File
+			l.loc.Start, l.loc.End = r.u32(), r.u32()
+			l.loc.StartPos.Line, l.loc.StartPos.Column = r.u32(), r.u32()
+			l.loc.EndPos.Line, l.loc.EndPos.Column = r.u32(), r.u32()
+			l.loc.Lineno, l.loc.StartPos.Offset, l.loc.EndPos.Offset = l.loc.StartPos.Line, l.loc.Start, l.loc.End
+			lines = append(lines, l)
+		}
+	}
+	if r.err == nil && r.pos != len(body) {
+		r.fail("%d bytes after the sections", len(body)-r.pos)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	if err := bc.decode(lines); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptObject, err)
+	}
+	return bc, nil
+}
+
+// ReadObjectFile loads the object file at path
+func ReadObjectFile(path string) (*Bytecode, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bc, err := ReadObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return bc, nil
+}
+
+// decode finds the instructions of loaded code, checks its tables point
+// at them and spreads the line table over them
+func (bc *Bytecode) decode(lines []line) error {
+	for offset := 0; offset < len(bc.Code); {
+		op := Opcode(bc.Code[offset])
+		if op >= numOpcodes {
+			return fmt.Errorf("no opcode %d at %d", byte(op), offset)
+		}
+		if offset+op.size() > len(bc.Code) {
+			return fmt.Errorf("%v at %d runs past the code", op, offset)
+		}
+		switch op {
+		case ILOAD_LOCAL, ISTORE_LOCAL, FLOAD_LOCAL, FSTORE_LOCAL, ILOAD_GLOBAL, ISTORE_GLOBAL, FLOAD_GLOBAL, FSTORE_GLOBAL:
+			if n := binary.LittleEndian.Uint32(bc.Code[offset+1:]); n >= MaxSlots {
+				return fmt.Errorf("%v %d at %d, past the %d slots there can be", op, n, offset, MaxSlots)
+			}
+		}
+		bc.starts = append(bc.starts, offset)
+		offset += op.size()
+	}
+	if len(bc.starts) == 0 || Opcode(bc.Code[bc.starts[len(bc.starts)-1]]) != HALT {
+		return fmt.Errorf("code doesn't end with HALT")
+	}
+	if bc.Globals > MaxSlots {
+		return fmt.Errorf("%d globals, there can be %d", bc.Globals, MaxSlots)
+	}
+	for i, f := range bc.Functions {
+		if f.Locals > MaxSlots {
+			return fmt.Errorf("function '%s' has %d locals, there can be %d", f.Name, f.Locals, MaxSlots)
+		}
+		if !bc.isStart(f.Entry) {
+			return fmt.Errorf("function '%s' enters at %d, not an instruction", f.Name, f.Entry)
+		}
+		if i > 0 && f.Entry <= bc.Functions[i-1].Entry {
+			return fmt.Errorf("function '%s' out of order", f.Name)
+		}
+	}
+	bc.index()
+	if lines == nil {
+		return nil
+	}
+	bc.locs = make([]model.Locator, len(bc.starts))
+	for i, l := range lines {
+		if !bc.isStart(l.offset) || (i > 0 && l.offset <= lines[i-1].offset) {
+			return fmt.Errorf("line table entry %d at %d, not an instruction", i, l.offset)
+		}
+		end := len(bc.Code)
+		if i+1 < len(lines) {
+			end = lines[i+1].offset
+		}
+		for j := bc.instruction(l.offset); j < len(bc.starts) && bc.starts[j] < end; j++ {
+			bc.locs[j] = l.loc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package wvm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"wabbit-go/model"
+)
+
+// ErrInvalidCode is the error of bytecode that doesn't pass Verify
+var ErrInvalidCode = errors.New("invalid bytecode")
+
+// depths are the heights of the int and the float stack
+type depths struct {
+	ints   int
+	floats int
+}
+
+// depthsOf gives the stack heights taken by values of types
+func depthsOf(types ...string) depths {
+	var d depths
+	for _, typ := range types {
+		if typ == "float" {
+			d.floats++
+		} else {
+			// int, bool and char are all ints
+			d.ints++
+		}
+	}
+	return d
+}
+
+// effects are the values an instruction pops and pushes, the ones whose
+// operand says are left out
+var effects = [numOpcodes]struct{ pop, push depths }{
+	IPUSH: {push: depths{ints: 1}},
+	IPOP:  {pop: depths{ints: 1}},
+	IDUP:  {pop: depths{ints: 1}, push: depths{ints: 2}},
+	IADD:  {pop: depths{ints: 2}, push: depths{ints: 1}},
+	ISUB:  {pop: depths{ints: 2}, push: depths{ints: 1}},
+	IMUL:  {pop: depths{ints: 2}, push: depths{ints: 1}},
+	IDIV:  {pop: depths{ints: 2}, push: depths{ints: 1}},
+	INEG:  {pop: depths{ints: 1}, push: depths{ints: 1}},
+	ICMP:  {pop: depths{ints: 2}, push: depths{ints: 1}},
+	AND:   {pop: depths{ints: 2}, push: depths{ints: 1}},
+	OR:    {pop: depths{ints: 2}, push: depths{ints: 1}},
+	XOR:   {pop: depths{ints: 2}, push: depths{ints: 1}},
+	FPUSH: {push: depths{floats: 1}},
+	FPOP:  {pop: depths{floats: 1}},
+	FDUP:  {pop: depths{floats: 1}, push: depths{floats: 2}},
+	FADD:  {pop: depths{floats: 2}, push: depths{floats: 1}},
+	FSUB:  {pop: depths{floats: 2}, push: depths{floats: 1}},
+	FMUL:  {pop: depths{floats: 2}, push: depths{floats: 1}},
+	FDIV:  {pop: depths{floats: 2}, push: depths{floats: 1}},
+	FNEG:  {pop: depths{floats: 1}, push: depths{floats: 1}},
+	FCMP:  {pop: depths{floats: 2}, push: depths{ints: 1}},
+	ITOF:  {pop: depths{ints: 1}, push: depths{floats: 1}},
+	FTOI:  {pop: depths{floats: 1}, push: depths{ints: 1}},
+
+	PRINTI: {pop: depths{ints: 1}},
+	PRINTF: {pop: depths{floats: 1}},
+	PRINTB: {pop: depths{ints: 1}},
+	PRINTC: {pop: depths{ints: 1}},
+
+	ILOAD_LOCAL:   {push: depths{ints: 1}},
+	ISTORE_LOCAL:  {pop: depths{ints: 1}},
+	FLOAD_LOCAL:   {push: depths{floats: 1}},
+	FSTORE_LOCAL:  {pop: depths{floats: 1}},
+	ILOAD_GLOBAL:  {push: depths{ints: 1}},
+	ISTORE_GLOBAL: {pop: depths{ints: 1}},
+	FLOAD_GLOBAL:  {push: depths{floats: 1}},
+	FSTORE_GLOBAL: {pop: depths{floats: 1}},
+
+	BZ: {pop: depths{ints: 1}},
+}
+
+// verifier follows the paths of the code of every function
+type verifier struct {
+	bc      *Bytecode
+	at      []*depths // the stacks before each instruction, nil before it's reached
+	owners  []*Func   // the function each instruction was reached in
+	globals map[int]string
+	hosts   []*model.HostFunction
+	fit     bool // size the globals and frames to the slots reached instead of checking them
+}
+
+// Verify checks bc can run without breaking the WVM: on every path through
+// every function the int and float stacks never underflow and have the same
+// heights where paths join, functions return just their result, jumps and
+// calls go to instructions and functions, and the slots of locals and
+// globals are in range and keep to either ints or floats. The globals and
+// the locals of every function are just the slots its code uses, at most
+// MaxSlots. The errors wrap ErrInvalidCode.
+func (bc *Bytecode) Verify() error {
+	return bc.verify(false)
+}
+
+// fit sizes the globals and the frames of bc to the slots its code reaches,
+// code the compiler leaves after a return or a break can have more
+func (bc *Bytecode) fit() error {
+	return bc.verify(true)
+}
+
+func (bc *Bytecode) verify(fit bool) error {
+	v := &verifier{
+		bc:      bc,
+		at:      make([]*depths, len(bc.starts)),
+		owners:  make([]*Func, len(bc.starts)),
+		globals: map[int]string{},
+		fit:     fit,
+	}
+	if bc.Globals > MaxSlots {
+		return fmt.Errorf("%w: %d globals, there can be %d", ErrInvalidCode, bc.Globals, MaxSlots)
+	}
+	for _, f := range bc.Functions {
+		if f.Locals > MaxSlots {
+			return fmt.Errorf("%w: function '%s' has %d locals, there can be %d", ErrInvalidCode, f.Name, f.Locals, MaxSlots)
+		}
+	}
+	for _, signature := range bc.Hosts {
+		f, err := model.ParseSignature(signature)
+		if err != nil {
+			return fmt.Errorf("%w: host function %s: %v", ErrInvalidCode, signature, err)
+		}
+		v.hosts = append(v.hosts, f)
+	}
+	if len(bc.starts) == 0 {
+		return fmt.Errorf("%w: no code", ErrInvalidCode)
+	}
+	if err := v.function(&Func{Name: model.TopLevel}, 0, depths{}); err != nil {
+		return err
+	}
+	for _, f := range bc.Functions {
+		if err := v.function(f, f.Entry, depthsOf(f.Params...)); err != nil {
+			return err
+		}
+	}
+	if used := slotsUsed(v.globals); v.fit {
+		bc.Globals = used
+	} else if bc.Globals != used {
+		return fmt.Errorf("%w: %d globals, the code uses %d", ErrInvalidCode, bc.Globals, used)
+	}
+	return nil
+}
+
+// function follows the code of f from its entry, where the stacks hold
+// entry
+func (v *verifier) function(f *Func, entry int, start depths) error {
+	locals := map[int]string{}
+	work := []int{v.bc.instruction(entry)}
+	v.at[work[0]] = &start
+	v.owners[work[0]] = f
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		next, err := v.step(f, i, locals)
+		if err != nil {
+			offset := v.bc.starts[i]
+			return fmt.Errorf("%w: %s at %d, %s: %v", ErrInvalidCode, f.Name, offset, v.bc.disassemble(offset, v.bc.labelNames()), err)
+		}
+		for _, n := range next {
+			j := v.bc.instruction(n.offset)
+			switch {
+			case v.at[j] == nil:
+				v.at[j], v.owners[j] = &n.depths, f
+				work = append(work, j)
+			case v.owners[j] != f:
+				return fmt.Errorf("%w: %s jumps to %d in %s", ErrInvalidCode, f.Name, n.offset, v.owners[j].Name)
+			case *v.at[j] != n.depths:
+				return fmt.Errorf("%w: %s at %d: stacks of %d ints and %d floats, %d and %d on another path", ErrInvalidCode,
+					f.Name, n.offset, n.depths.ints, n.depths.floats, v.at[j].ints, v.at[j].floats)
+			}
+		}
+	}
+	if used := slotsUsed(locals); v.fit {
+		f.Locals = used
+	} else if f.Locals != used {
+		return fmt.Errorf("%w: function '%s' has %d locals, the code uses %d", ErrInvalidCode, f.Name, f.Locals, used)
+	}
+	return nil
+}
+
+// slotsUsed gives the slots taken by the ones used, up to the highest
+func slotsUsed(slots map[int]string) int {
+	n := 0
+	for slot := range slots {
+		if slot >= n {
+			n = slot + 1
+		}
+	}
+	return n
+}
+
+// successor is where an instruction goes on to, with the stacks after it
+type successor struct {
+	offset int
+	depths depths
+}
+
+// step checks instruction i of f and gives where it goes on to
+func (v *verifier) step(f *Func, i int, locals map[int]string) ([]successor, error) {
+	bc := v.bc
+	offset := bc.starts[i]
+	op := Opcode(bc.Code[offset])
+	next := offset + op.size()
+	n := 0
+	if operands[op] == u32Operand {
+		n = int(binary.LittleEndian.Uint32(bc.Code[offset+1:]))
+	}
+	d := *v.at[i]
+	pop, push := effects[op].pop, effects[op].push
+	switch op {
+	case ICMP, FCMP:
+		if int(bc.Code[offset+1]) >= len(comparisons) {
+			return nil, fmt.Errorf("no comparison %d", bc.Code[offset+1])
+		}
+	case ILOAD_LOCAL, ISTORE_LOCAL, FLOAD_LOCAL, FSTORE_LOCAL:
+		if n >= f.Locals {
+			return nil, fmt.Errorf("slot %d of %d locals", n, f.Locals)
+		}
+		if err := sameKind(locals, n, op); err != nil {
+			return nil, err
+		}
+	case ILOAD_GLOBAL, ISTORE_GLOBAL, FLOAD_GLOBAL, FSTORE_GLOBAL:
+		if n >= bc.Globals {
+			return nil, fmt.Errorf("slot %d of %d globals", n, bc.Globals)
+		}
+		if err := sameKind(v.globals, n, op); err != nil {
+			return nil, err
+		}
+	case GOTO, BZ:
+		if !bc.isStart(n) {
+			return nil, fmt.Errorf("jump to %d, not an instruction", n)
+		}
+	case CALL, TAIL_CALL:
+		callee := bc.entries[n]
+		if callee == nil {
+			return nil, fmt.Errorf("call to %d, not a function", n)
+		}
+		pop, push = depthsOf(callee.Params...), depthsOf(callee.Result)
+		if op == TAIL_CALL && (d != pop || callee.Result != f.Result) {
+			return nil, fmt.Errorf("tail call of %s with stacks of %d ints and %d floats from a function of %s", callee.Signature(), d.ints, d.floats, f.Result)
+		}
+	case HOST_CALL:
+		if n >= len(v.hosts) {
+			return nil, fmt.Errorf("no host function %d", n)
+		}
+		pop, push = depthsOf(v.hosts[n].Params...), depthsOf(v.hosts[n].Result)
+	case RETURN:
+		if f.Result == "" {
+			return nil, fmt.Errorf("return outside of a function")
+		}
+		if want := depthsOf(f.Result); d != want {
+			return nil, fmt.Errorf("return of %s with stacks of %d ints and %d floats", f.Result, d.ints, d.floats)
+		}
+	}
+	if d.ints < pop.ints || d.floats < pop.floats {
+		return nil, fmt.Errorf("pops %d ints and %d floats from stacks of %d and %d", pop.ints, pop.floats, d.ints, d.floats)
+	}
+	d = depths{d.ints - pop.ints + push.ints, d.floats - pop.floats + push.floats}
+
+	switch op {
+	case HALT, RETURN, TAIL_CALL:
+		return nil, nil
+	case GOTO:
+		return []successor{{n, d}}, nil
+	case BZ:
+		if next >= len(bc.Code) {
+			return nil, fmt.Errorf("runs past the end of the code")
+		}
+		return []successor{{n, d}, {next, d}}, nil
+	}
+	if next >= len(bc.Code) {
+		return nil, fmt.Errorf("runs past the end of the code")
+	}
+	return []successor{{next, d}}, nil
+}
+
+// sameKind checks the slot n of slots, locals or globals, is used by op
+// for the values it was used for before
+func sameKind(slots map[int]string, n int, op Opcode) error {
+	kind := "int"
+	switch op {
+	case FLOAD_LOCAL, FSTORE_LOCAL, FLOAD_GLOBAL, FSTORE_GLOBAL:
+		kind = "float"
+	}
+	if was, ok := slots[n]; ok && was != kind {
+		return fmt.Errorf("slot %d holds %ss, not %ss", n, was, kind)
+	}
+	slots[n] = kind
+	return nil
+}
+
+// isStart tells whether an instruction starts at offset
+func (bc *Bytecode) isStart(offset int) bool {
+	i := bc.instruction(offset)
+	return i >= 0 && i < len(bc.starts) && bc.starts[i] == offset
+}
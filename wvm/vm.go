@@ -0,0 +1,433 @@
+package wvm
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+	"wabbit-go/common"
+	"wabbit-go/model"
+)
+
+// Frame is a call running, its locals are the slots from base on
+type Frame struct {
+	returnPc int
+	base     int
+	size     int // slots of the function
+	function string
+}
+
+// WVM runs bytecode on a stack of ints and a stack of floats. The locals of
+// the frames are stacked the same way, a slot of a function holds an int or
+// a float and has a place in both.
+type WVM struct {
+	pc       int
+	istack   []int
+	fstack   []float64
+	ilocals  []int
+	flocals  []float64
+	iglobals []int
+	fglobals []float64
+	frames   []Frame
+	base     int // of the locals of the last frame
+	options  *common.RunOptions
+	limiter  *common.Limiter
+	bytecode *Bytecode
+	hosts    []*model.HostFunction // of bytecode.Hosts
+}
+
+// NewWVM makes a WVM for bytecode once it is verified, the host functions it
+// calls must be registered in host with the same signatures
+func NewWVM(ctx context.Context, bytecode *Bytecode, host *model.Host, options common.RunOptions) (*WVM, error) {
+	if err := bytecode.Verify(); err != nil {
+		return nil, err
+	}
+	vm := &WVM{
+		iglobals: make([]int, bytecode.Globals),
+		fglobals: make([]float64, bytecode.Globals),
+		options:  options.WithDefaults(),
+		bytecode: bytecode,
+	}
+	vm.limiter = common.NewLimiter(ctx, vm.options)
+	for _, signature := range bytecode.Hosts {
+		name, _, _ := strings.Cut(signature, "(")
+		f := host.Lookup(name)
+		if f == nil || f.Signature() != signature {
+			return nil, fmt.Errorf("no host function %s", signature)
+		}
+		vm.hosts = append(vm.hosts, f)
+	}
+	return vm, nil
+}
+
+// location gives where the instruction pc is in came from
+func (vm *WVM) location(pc int) model.Locator {
+	return vm.bytecode.location(pc)
+}
+
+// stop ends the program at the instruction before pc for err
+func (vm *WVM) stop(err error) {
+	e := vm.runtimeError(err.Error())
+	e.Err = err
+	panic(e)
+}
+
+// printed stops the program when what it printed couldn't be written
+func (vm *WVM) printed(_ int, err error) {
+	if err != nil {
+		vm.stop(err)
+	}
+}
+
+// runtimeError gives the error stopping the instruction before pc, with the
+// stack of the frames
+func (vm *WVM) runtimeError(message string) *model.RuntimeError {
+	loc := vm.location(vm.pc - 1)
+	e := &model.RuntimeError{Message: message, Loc: loc}
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		e.Stack = append(e.Stack, model.StackFrame{Function: frame.function, Loc: loc})
+		// the CALL that made the frame
+		loc = vm.location(frame.returnPc - 1)
+	}
+	e.Stack = append(e.Stack, model.StackFrame{Function: model.TopLevel, Loc: loc})
+	return e
+}
+
+// recover turns r, a runtime error or a Go runtime panic of an instruction,
+// into the error of the run
+func (vm *WVM) recover(r interface{}, err *error) {
+	switch e := r.(type) {
+	case nil:
+	case *model.RuntimeError:
+		*err = e
+	case runtime.Error:
+		*err = vm.runtimeError(e.Error())
+	default:
+		panic(r)
+	}
+}
+
+func (vm *WVM) ipush(value int) {
+	vm.istack = append(vm.istack, value)
+}
+
+func (vm *WVM) ipop() int {
+	index := len(vm.istack) - 1
+	element := vm.istack[index]
+	vm.istack = vm.istack[:index]
+	return element
+}
+
+func (vm *WVM) fpush(value float64) {
+	vm.fstack = append(vm.fstack, value)
+}
+
+func (vm *WVM) fpop() float64 {
+	index := len(vm.fstack) - 1
+	element := vm.fstack[index]
+	vm.fstack = vm.fstack[:index]
+	return element
+}
+
+func BoolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func compareInts(cmp byte, left int, right int) bool {
+	switch cmp {
+	case cmpLt:
+		return left < right
+	case cmpLe:
+		return left <= right
+	case cmpGt:
+		return left > right
+	case cmpGe:
+		return left >= right
+	case cmpEq:
+		return left == right
+	}
+	return left != right
+}
+
+func compareFloats(cmp byte, left float64, right float64) bool {
+	switch cmp {
+	case cmpLt:
+		return left < right
+	case cmpLe:
+		return left <= right
+	case cmpGt:
+		return left > right
+	case cmpGe:
+		return left >= right
+	case cmpEq:
+		return left == right
+	}
+	return left != right
+}
+
+// call makes the frame of a call to the function at offset, returning to pc
+func (vm *WVM) call(offset int, pc int) {
+	if len(vm.frames) >= vm.options.MaxDepth {
+		vm.stop(common.ErrDepthLimit)
+	}
+	f := vm.bytecode.entries[offset]
+	base := 0
+	if n := len(vm.frames); n > 0 {
+		base = vm.frames[n-1].base + vm.frames[n-1].size
+	}
+	vm.frames = append(vm.frames, Frame{returnPc: pc, base: base, function: f.Name})
+	vm.enter(f)
+}
+
+// enter gives the last frame the zeroed slots of f
+func (vm *WVM) enter(f *Func) {
+	frame := &vm.frames[len(vm.frames)-1]
+	frame.size, frame.function = f.Locals, f.Name
+	vm.base = frame.base
+	end := frame.base + f.Locals
+	for len(vm.ilocals) < end {
+		vm.ilocals = append(vm.ilocals, 0)
+		vm.flocals = append(vm.flocals, 0)
+	}
+	for i := frame.base; i < end; i++ {
+		vm.ilocals[i], vm.flocals[i] = 0, 0
+	}
+}
+
+// ret leaves the last frame and gives where it returns to
+func (vm *WVM) ret() int {
+	n := len(vm.frames) - 1
+	pc := vm.frames[n].returnPc
+	vm.frames = vm.frames[:n]
+	if n > 0 {
+		vm.base = vm.frames[n-1].base
+	}
+	return pc
+}
+
+// hostCall calls the host function f, its arguments are on the stacks of
+// their types and so is its result
+func (vm *WVM) hostCall(f *model.HostFunction) {
+	args := make([]interface{}, len(f.Params))
+	for i := len(f.Params) - 1; i >= 0; i-- {
+		switch f.Params[i] {
+		case "float":
+			args[i] = vm.fpop()
+		case "bool":
+			args[i] = vm.ipop() != 0
+		case "char":
+			args[i] = rune(vm.ipop())
+		default:
+			args[i] = int64(vm.ipop())
+		}
+	}
+	result, err := f.Func(args)
+	if err != nil {
+		vm.stop(err)
+	}
+	switch v := result.(type) {
+	case float64:
+		if f.Result == "float" {
+			vm.fpush(v)
+			return
+		}
+	case int64:
+		if f.Result == "int" {
+			vm.ipush(int(v))
+			return
+		}
+	case int:
+		if f.Result == "int" {
+			vm.ipush(v)
+			return
+		}
+	case bool:
+		if f.Result == "bool" {
+			vm.ipush(BoolToInt(v))
+			return
+		}
+	case rune:
+		if f.Result == "char" {
+			vm.ipush(int(v))
+			return
+		}
+	}
+	panic(vm.runtimeError(fmt.Sprintf("host function '%s' returned %T, want %s", f.Name, result, f.Result)))
+}
+
+// run runs the bytecode from pc until HALT
+func (vm *WVM) run(pc int) (err error) {
+	code := vm.bytecode.Code
+	defer func() {
+		vm.pc = pc
+		vm.recover(recover(), &err)
+	}()
+	for {
+		if err := vm.limiter.Step(); err != nil {
+			vm.pc = pc + 1
+			vm.stop(err)
+		}
+		op := Opcode(code[pc])
+		pc++
+		switch op {
+		case IPUSH:
+			vm.istack = append(vm.istack, int(binary.LittleEndian.Uint64(code[pc:])))
+			pc += 8
+		case IPOP:
+			vm.istack = vm.istack[:len(vm.istack)-1]
+		case IDUP:
+			vm.istack = append(vm.istack, vm.istack[len(vm.istack)-1])
+		case IADD:
+			n := len(vm.istack) - 1
+			vm.istack[n-1] += vm.istack[n]
+			vm.istack = vm.istack[:n]
+		case ISUB:
+			n := len(vm.istack) - 1
+			vm.istack[n-1] -= vm.istack[n]
+			vm.istack = vm.istack[:n]
+		case IMUL:
+			n := len(vm.istack) - 1
+			vm.istack[n-1] *= vm.istack[n]
+			vm.istack = vm.istack[:n]
+		case IDIV:
+			n := len(vm.istack) - 1
+			if vm.istack[n] == 0 {
+				vm.pc = pc
+				panic(vm.runtimeError("division by zero"))
+			}
+			vm.istack[n-1] /= vm.istack[n]
+			vm.istack = vm.istack[:n]
+		case INEG:
+			n := len(vm.istack) - 1
+			vm.istack[n] = -vm.istack[n]
+		case ICMP:
+			n := len(vm.istack) - 1
+			vm.istack[n-1] = BoolToInt(compareInts(code[pc], vm.istack[n-1], vm.istack[n]))
+			vm.istack = vm.istack[:n]
+			pc++
+		case AND:
+			n := len(vm.istack) - 1
+			vm.istack[n-1] &= vm.istack[n]
+			vm.istack = vm.istack[:n]
+		case OR:
+			n := len(vm.istack) - 1
+			vm.istack[n-1] |= vm.istack[n]
+			vm.istack = vm.istack[:n]
+		case XOR:
+			n := len(vm.istack) - 1
+			vm.istack[n-1] ^= vm.istack[n]
+			vm.istack = vm.istack[:n]
+
+		case FPUSH:
+			vm.fstack = append(vm.fstack, math.Float64frombits(binary.LittleEndian.Uint64(code[pc:])))
+			pc += 8
+		case FPOP:
+			vm.fstack = vm.fstack[:len(vm.fstack)-1]
+		case FDUP:
+			vm.fstack = append(vm.fstack, vm.fstack[len(vm.fstack)-1])
+		case FADD:
+			n := len(vm.fstack) - 1
+			vm.fstack[n-1] += vm.fstack[n]
+			vm.fstack = vm.fstack[:n]
+		case FSUB:
+			n := len(vm.fstack) - 1
+			vm.fstack[n-1] -= vm.fstack[n]
+			vm.fstack = vm.fstack[:n]
+		case FMUL:
+			n := len(vm.fstack) - 1
+			vm.fstack[n-1] *= vm.fstack[n]
+			vm.fstack = vm.fstack[:n]
+		case FDIV:
+			n := len(vm.fstack) - 1
+			vm.fstack[n-1] /= vm.fstack[n]
+			vm.fstack = vm.fstack[:n]
+		case FNEG:
+			n := len(vm.fstack) - 1
+			vm.fstack[n] = -vm.fstack[n]
+		case FCMP:
+			n := len(vm.fstack) - 1
+			vm.ipush(BoolToInt(compareFloats(code[pc], vm.fstack[n-1], vm.fstack[n])))
+			vm.fstack = vm.fstack[:n-1]
+			pc++
+		case ITOF:
+			vm.fpush(float64(vm.ipop()))
+		case FTOI:
+			vm.ipush(int(vm.fpop()))
+
+		case PRINTI:
+			vm.pc = pc
+			vm.printed(fmt.Fprintln(vm.options.Stdout, vm.ipop()))
+		case PRINTF:
+			vm.pc = pc
+			vm.printed(fmt.Fprintln(vm.options.Stdout, vm.fpop()))
+		case PRINTB:
+			vm.pc = pc
+			vm.printed(fmt.Fprintln(vm.options.Stdout, vm.ipop() != 0))
+		case PRINTC:
+			vm.pc = pc
+			vm.printed(fmt.Fprintf(vm.options.Stdout, "%c", rune(vm.ipop())))
+
+		case ILOAD_LOCAL:
+			vm.istack = append(vm.istack, vm.ilocals[vm.base+int(binary.LittleEndian.Uint32(code[pc:]))])
+			pc += 4
+		case ISTORE_LOCAL:
+			vm.ilocals[vm.base+int(binary.LittleEndian.Uint32(code[pc:]))] = vm.ipop()
+			pc += 4
+		case FLOAD_LOCAL:
+			vm.fstack = append(vm.fstack, vm.flocals[vm.base+int(binary.LittleEndian.Uint32(code[pc:]))])
+			pc += 4
+		case FSTORE_LOCAL:
+			vm.flocals[vm.base+int(binary.LittleEndian.Uint32(code[pc:]))] = vm.fpop()
+			pc += 4
+		case ILOAD_GLOBAL:
+			vm.istack = append(vm.istack, vm.iglobals[binary.LittleEndian.Uint32(code[pc:])])
+			pc += 4
+		case ISTORE_GLOBAL:
+			vm.iglobals[binary.LittleEndian.Uint32(code[pc:])] = vm.ipop()
+			pc += 4
+		case FLOAD_GLOBAL:
+			vm.fstack = append(vm.fstack, vm.fglobals[binary.LittleEndian.Uint32(code[pc:])])
+			pc += 4
+		case FSTORE_GLOBAL:
+			vm.fglobals[binary.LittleEndian.Uint32(code[pc:])] = vm.fpop()
+			pc += 4
+
+		case GOTO:
+			pc = int(binary.LittleEndian.Uint32(code[pc:]))
+		case BZ:
+			if vm.ipop() == 0 {
+				pc = int(binary.LittleEndian.Uint32(code[pc:]))
+			} else {
+				pc += 4
+			}
+		case CALL:
+			offset := int(binary.LittleEndian.Uint32(code[pc:]))
+			pc += 4
+			vm.pc = pc
+			vm.call(offset, pc)
+			pc = offset
+		case TAIL_CALL:
+			// the same frame, for the function called
+			pc = int(binary.LittleEndian.Uint32(code[pc:]))
+			vm.enter(vm.bytecode.entries[pc])
+		case RETURN:
+			pc = vm.ret()
+		case HOST_CALL:
+			f := vm.hosts[binary.LittleEndian.Uint32(code[pc:])]
+			pc += 4
+			vm.pc = pc
+			vm.hostCall(f)
+		case HALT:
+			return nil
+		default:
+			vm.pc = pc
+			panic(vm.runtimeError(fmt.Sprintf("no such opcode %v", op)))
+		}
+	}
+}
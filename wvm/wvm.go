@@ -1,418 +1,25 @@
 package wvm
 
 import (
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"strconv"
+	"strings"
 	"wabbit-go/common"
 	"wabbit-go/model"
 )
 
-type Frame struct {
-	returnPc  int
-	locals    map[int]interface{}
-	prevFrame *Frame
-}
-
-type WVM struct {
-	pc      int
-	istack  []int
-	fstack  []float64
-	globals map[int]interface{}
-	labels  map[int]int
-	frame   *Frame
-	running bool
-}
-
-type OpFunc func(args interface{}) interface{}
-
 type Instruction struct {
 	opcode string
 	args   interface{}
 }
 
-func NewWVM() *WVM {
-	wvm := WVM{
-		globals: make(map[int]interface{}),
-	}
-
-	return &wvm
-}
-
-// At now we don't have bytecode just instruction
-func (vm *WVM) run(instructions []Instruction) {
-	vm.pc = 0
-	vm.running = true
-
-	// Should update Instruction to Thread code
-	opMap := vm.getOpcodeMap()
-
-	for vm.running {
-		op := instructions[vm.pc].opcode
-		args := instructions[vm.pc].args
-		vm.pc++
-
-		if op == "LABEL" {
-			continue
-		}
-		fn, exists := opMap[op]
-		if !exists {
-			// 错误处理：未知的字节码
-			panic(fmt.Sprintf("no such opcode %v", op))
-		}
-		fn(args)
-
-	}
-}
-
-func (vm *WVM) IPUSH(value interface{}) interface{} {
-	vm.istack = append(vm.istack, value.(int)) // reflect vs cast
-	return nil
-}
-
-func (vm *WVM) IPOP(value interface{}) interface{} {
-	index := len(vm.istack) - 1
-	// Get the top element of the stack
-	element := vm.istack[index]
-	// Remove the top element of the stack
-	vm.istack = vm.istack[:index]
-	return element
-}
-
-func (vm *WVM) IDUP(value interface{}) interface{} {
-	vm.istack = append(vm.istack, vm.istack[len(vm.istack)-1])
-	return nil
-}
-
-func (vm *WVM) FDUP(value interface{}) interface{} {
-	vm.fstack = append(vm.fstack, vm.fstack[len(vm.fstack)-1])
-	return nil
-}
-
-func (vm *WVM) IADD(value interface{}) interface{} {
-	right := (vm.IPOP(nil)).(int)
-	left := (vm.IPOP(nil)).(int)
-	vm.IPUSH(left + right)
-	return nil
-}
-
-func (vm *WVM) FADD(value interface{}) interface{} {
-	right := (vm.FPOP(nil)).(float64)
-	left := (vm.FPOP(nil)).(float64)
-	vm.FPUSH(left + right)
-	return nil
-}
-
-func (vm *WVM) ISUB(value interface{}) interface{} {
-	right := (vm.IPOP(nil)).(int)
-	left := (vm.IPOP(nil)).(int)
-	vm.IPUSH(left - right)
-	return nil
-}
-
-func (vm *WVM) FSUB(value interface{}) interface{} {
-	right := (vm.FPOP(nil)).(float64)
-	left := (vm.FPOP(nil)).(float64)
-	vm.FPUSH(left - right)
-	return nil
-}
-
-func (vm *WVM) IMUL(value interface{}) interface{} {
-	right := (vm.IPOP(nil)).(int)
-	left := (vm.IPOP(nil)).(int)
-	vm.IPUSH(left * right)
-	return nil
-}
-
-func (vm *WVM) FMUL(value interface{}) interface{} {
-	right := (vm.FPOP(nil)).(float64)
-	left := (vm.FPOP(nil)).(float64)
-	vm.FPUSH(left * right)
-	return nil
-}
-
-func (vm *WVM) IDIV(value interface{}) interface{} {
-	right := (vm.IPOP(nil)).(int)
-	left := (vm.IPOP(nil)).(int)
-	vm.IPUSH(left / right)
-	return nil
-}
-
-func (vm *WVM) FDIV(value interface{}) interface{} {
-	right := (vm.FPOP(nil)).(float64)
-	left := (vm.FPOP(nil)).(float64)
-	vm.FPUSH(left / right)
-	return nil
-}
-
-func (vm *WVM) AND(value interface{}) interface{} {
-	right := (vm.IPOP(nil)).(int)
-	left := (vm.IPOP(nil)).(int)
-	vm.IPUSH(left & right)
-	return nil
-}
-
-func (vm *WVM) OR(value interface{}) interface{} {
-	right := vm.IPOP(nil).(int)
-	left := vm.IPOP(nil).(int)
-	vm.IPUSH(left | right)
-	return nil
-}
-
-func (vm *WVM) XOR(value interface{}) interface{} {
-	right := (vm.IPOP(nil)).(int)
-	left := (vm.IPOP(nil)).(int)
-	vm.IPUSH(left ^ right)
-	return nil
-}
-
-func BoolToInt(b bool) int {
-	if b {
-		return 1
-	}
-	return 0
-}
-
-func (vm *WVM) ICMP(value interface{}) interface{} {
-	right := (vm.IPOP(nil)).(int)
-	left := (vm.IPOP(nil)).(int)
-	op := value.(string)
-	switch op {
-	case "<":
-		vm.IPUSH(BoolToInt(left < right))
-	case "<=":
-		vm.IPUSH(BoolToInt(left <= right))
-	case ">":
-		vm.IPUSH(BoolToInt(left > right))
-	case ">=":
-		vm.IPUSH(BoolToInt(left >= right))
-	case "==":
-		vm.IPUSH(BoolToInt(left == right))
-	case "!=":
-		vm.IPUSH(BoolToInt(left != right))
-	}
-	return nil
-}
-
-func (vm *WVM) FCMP(value interface{}) interface{} {
-	right := (vm.FPOP(nil)).(float64)
-	left := (vm.FPOP(nil)).(float64)
-	op := value.(string)
-	switch op {
-	case "<":
-		vm.IPUSH(BoolToInt(left < right))
-	case "<=":
-		vm.IPUSH(BoolToInt(left <= right))
-	case ">":
-		vm.IPUSH(BoolToInt(left > right))
-	case ">=":
-		vm.IPUSH(BoolToInt(left >= right))
-	case "==":
-		vm.IPUSH(BoolToInt(left == right))
-	case "!=":
-		vm.IPUSH(BoolToInt(left != right))
-	}
-	return nil
-}
-
-func (vm *WVM) FPUSH(value interface{}) interface{} {
-	vm.fstack = append(vm.fstack, value.(float64))
-	return nil
-}
-
-func (vm *WVM) INEG(value interface{}) interface{} {
-	vm.IPUSH(-(vm.IPOP(nil).(int)))
-	return nil
-}
-
-func (vm *WVM) FPOP(value interface{}) interface{} {
-	index := len(vm.fstack) - 1
-	// Get the top element of the stack
-	element := vm.fstack[index]
-	// Remove the top element of the stack
-	vm.fstack = vm.fstack[:index]
-	return element
-}
-
-func (vm *WVM) FNEG(value interface{}) interface{} {
-	vm.FPUSH(-(vm.FPOP(nil)).(float64))
-	return nil
-}
-
-func (vm *WVM) PRINTI(value interface{}) interface{} {
-	fmt.Println((vm.IPOP(nil)).(int))
-	return nil
-}
-
-func (vm *WVM) PRINTF(value interface{}) interface{} {
-	fmt.Println((vm.FPOP(nil)).(float64))
-	return nil
-}
-
-func (vm *WVM) PRINTB(value interface{}) interface{} {
-	if (vm.IPOP(nil)).(int) == 0 {
-		fmt.Println("false")
-	} else {
-		fmt.Println("true")
-	}
-	return nil
-}
-
-func (vm *WVM) PRINTC(value interface{}) interface{} {
-	fmt.Printf("%c", rune((vm.IPOP(nil)).(int)))
-	return nil
-}
-
-func (vm *WVM) FTOI(value interface{}) interface{} {
-	vm.IPUSH(int((vm.FPOP(nil)).(float64)))
-	return nil
-}
-
-func (vm *WVM) ITOF(value interface{}) interface{} {
-	vm.FPUSH(float64((vm.IPOP(nil)).(int)))
-	return nil
-}
-
-func (vm *WVM) ISTORE_LOCAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.frame.locals[slot] = vm.IPOP(nil).(int)
-	return nil
-}
-
-func (vm *WVM) ILOAD_LOCAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.IPUSH(vm.frame.locals[slot].(int))
-	return nil
-}
-
-func (vm *WVM) FLOAD_LOCAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.FPUSH(vm.frame.locals[slot].(float64))
-	return nil
-}
-
-func (vm *WVM) FSTORE_LOCAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.frame.locals[slot] = vm.FPOP(nil).(float64)
-	return nil
-}
-
-func (vm *WVM) ISTORE_GLOBAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.globals[slot] = vm.IPOP(nil).(int)
-	return nil
-}
-
-func (vm *WVM) ILOAD_GLOBAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.IPUSH(vm.globals[slot].(int))
-	return nil
-}
-
-func (vm *WVM) FLOAD_GLOBAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.FPUSH(vm.globals[slot].(float64))
-	return nil
-}
-
-func (vm *WVM) GOTO(value interface{}) interface{} {
-	name := value.(int)
-	vm.pc = vm.labels[name]
-	return nil
-}
-
-func (vm *WVM) BZ(value interface{}) interface{} {
-	name := value.(int)
-	if vm.IPOP(nil).(int) == 0 {
-		vm.pc = vm.labels[name]
-	}
-	return nil
-}
-
-func (vm *WVM) HALT(value interface{}) interface{} {
-	vm.running = false
-	return nil
-}
-
-func (vm *WVM) LABEL(value interface{}) interface{} {
-	return nil
-}
-
-func (vm *WVM) FSTORE_GLOBAL(value interface{}) interface{} {
-	slot := value.(int)
-	vm.globals[slot] = vm.FPOP(nil).(float64)
-	return nil
-}
-
-func (vm *WVM) CALL(value interface{}) interface{} {
-	label := value.(int)
-	// 这是通过指针链接起来的 frame
-	// 尾递归优化，需要使用当前 vm.frame
-	// 应该是有一个 TAILCALL
-	vm.frame = &Frame{vm.pc, make(map[int]interface{}), vm.frame}
-	vm.pc = vm.labels[label]
-	return nil
-}
-
-func (vm *WVM) TAIL_CALL(value interface{}) interface{} {
-	label := value.(int)
-	// vm.frame = &Frame{vm.pc, make(map[int]interface{}), vm.frame}
-	// we don't need save the frame, using the same one
-	vm.pc = vm.labels[label]
-	return nil
-}
-
-func (vm *WVM) RETURN(value interface{}) interface{} {
-	vm.pc = vm.frame.returnPc
-	vm.frame = vm.frame.prevFrame
-	return nil
-}
-
-func (vm *WVM) getOpcodeMap() map[string]OpFunc {
-	return map[string]OpFunc{
-		"IPUSH":         vm.IPUSH,
-		"IPOP":          vm.IPOP,
-		"IDUP":          vm.IDUP,
-		"FDUP":          vm.FDUP,
-		"IADD":          vm.IADD,
-		"FADD":          vm.FADD,
-		"ISUB":          vm.ISUB,
-		"FSUB":          vm.FSUB,
-		"IMUL":          vm.IMUL,
-		"FMUL":          vm.FMUL,
-		"IDIV":          vm.IDIV,
-		"FDIV":          vm.FDIV,
-		"AND":           vm.AND,
-		"OR":            vm.OR,
-		"XOR":           vm.XOR,
-		"ICMP":          vm.ICMP,
-		"FCMP":          vm.FCMP,
-		"FPUSH":         vm.FPUSH,
-		"INEG":          vm.INEG,
-		"FPOP":          vm.FPOP,
-		"FNEG":          vm.FNEG,
-		"PRINTI":        vm.PRINTI,
-		"PRINTF":        vm.PRINTF,
-		"PRINTB":        vm.PRINTB,
-		"PRINTC":        vm.PRINTC,
-		"FTOI":          vm.FTOI,
-		"ITOF":          vm.ITOF,
-		"ISTORE_LOCAL":  vm.ISTORE_LOCAL,
-		"ILOAD_LOCAL":   vm.ILOAD_LOCAL,
-		"FLOAD_LOCAL":   vm.FLOAD_LOCAL,
-		"FSTORE_LOCAL":  vm.FSTORE_LOCAL,
-		"ISTORE_GLOBAL": vm.ISTORE_GLOBAL,
-		"ILOAD_GLOBAL":  vm.ILOAD_GLOBAL,
-		"FLOAD_GLOBAL":  vm.FLOAD_GLOBAL,
-		"GOTO":          vm.GOTO,
-		"BZ":            vm.BZ,
-		"HALT":          vm.HALT,
-		"LABEL":         vm.LABEL,
-		"FSTORE_GLOBAL": vm.FSTORE_GLOBAL,
-		"CALL":          vm.CALL,
-		"TAIL_CALL":     vm.TAIL_CALL,
-		"RETURN":        vm.RETURN,
+func (i Instruction) String() string {
+	if i.args == nil {
+		return i.opcode
 	}
+	return fmt.Sprintf("%s %v", i.opcode, i.args)
 }
 
 type Function struct {
@@ -421,27 +28,38 @@ type Function struct {
 }
 
 type Context struct {
-	env       *common.ChainMap
-	code      []Instruction
-	labels    map[int]int
-	nglobals  int
-	nlocals   int
-	nlabels   int
-	scope     string
-	haveMain  bool
-	function  Function
-	parentEnv *map[string]interface{}
-}
-
-func NewWVMContext() *Context {
+	program      *model.Program
+	env          *common.ChainMap
+	code         []Instruction
+	node         model.Node                         // being generated
+	nodes        []model.Node                       // the node of each instruction
+	functions    map[int]*Func                      // by start label
+	declarations map[int]*model.FunctionDeclaration // by start label
+	nglobals     int
+	nlocals      int
+	nlabels      int
+	scope        string
+	haveMain     bool
+	function     Function
+	parentEnv    *map[string]interface{}
+}
+
+func NewWVMContext(program *model.Program) *Context {
 	return &Context{
-		env:    common.NewChainMap(),
-		scope:  "global",
-		code:   make([]Instruction, 0),
-		labels: make(map[int]int),
+		program:      program,
+		env:          common.NewChainMap(),
+		scope:        "global",
+		code:         make([]Instruction, 0),
+		functions:    make(map[int]*Func),
+		declarations: make(map[int]*model.FunctionDeclaration),
 	}
 }
 
+// TypeOf gives the type the checker recorded for node
+func (ctx *Context) TypeOf(node model.Node) string {
+	return ctx.program.TypeOf(node)
+}
+
 type WVMVar struct {
 	Type  string
 	Scope string
@@ -485,30 +103,197 @@ func (ctx *Context) NewScope(do func()) {
 	do()
 }
 
+// Discard pops the value of type a statement left on the stacks
+func (ctx *Context) Discard(typ string) {
+	// an assignment keeps no copy of the value it stores then
+	if n := len(ctx.code); n >= 2 && (ctx.code[n-2].opcode == "IDUP" || ctx.code[n-2].opcode == "FDUP") &&
+		strings.Contains(ctx.code[n-1].opcode, "STORE") {
+		ctx.code = append(ctx.code[:n-2], ctx.code[n-1])
+		ctx.nodes = append(ctx.nodes[:n-2], ctx.nodes[n-1])
+		return
+	}
+	switch typ {
+	case "":
+	case "float":
+		ctx.NewInstruction(Instruction{"FPOP", nil})
+	default:
+		// int, bool and char are all ints
+		ctx.NewInstruction(Instruction{"IPOP", nil})
+	}
+}
+
+// Declare defines the names the top-level statements of node declare,
+// without generating their code. The code of expressions using them can
+// then be generated, like the REPL does for :wvm.
+func Declare(node model.Node, ctx *Context) {
+	for _, statement := range node.(*model.Statements).Statements {
+		switch v := statement.(type) {
+		case *model.VarDeclaration:
+			scope, slot := ctx.NewVariable()
+			ctx.Define(v.Name.Text, &WVMVar{Type: ctx.TypeOf(v), Scope: scope, Slot: slot})
+		case *model.ConstDeclaration:
+			scope, slot := ctx.NewVariable()
+			ctx.Define(v.Name.Text, &WVMVar{Type: ctx.TypeOf(v), Scope: scope, Slot: slot})
+		case *model.FunctionDeclaration:
+			ctx.Define(v.Name.Text, &WVMVar{Type: v.ReturnType.Type(), Slot: ctx.NewLabel()})
+		}
+	}
+}
+
+// Fork gives a context with the names of ctx and none of its code, what
+// is generated in it leaves ctx as it is
+func (ctx *Context) Fork() *Context {
+	fork := NewWVMContext(ctx.program)
+	fork.env = ctx.env.NewChild()
+	fork.nglobals, fork.nlabels = ctx.nglobals, ctx.nlabels
+	return fork
+}
+
+// Code gives the instructions generated so far
+func (ctx *Context) Code() []Instruction {
+	return ctx.code
+}
+
 func (ctx *Context) NewInstruction(instruction Instruction) {
 	ctx.code = append(ctx.code, instruction)
-	if instruction.opcode == "LABEL" {
-		ctx.labels[instruction.args.(int)] = len(ctx.code) - 1
+	ctx.nodes = append(ctx.nodes, ctx.node)
+}
+
+// Wvm compiles program to WVM instructions and runs them, what it prints goes
+// to options.Stdout. A program stopped by a runtime error gives a
+// *model.RuntimeError.
+func Wvm(program *model.Program, options common.RunOptions) error {
+	return WvmContext(context.Background(), program, options)
+}
+
+// WvmContext runs program until it halts, fails, reaches a limit of options
+// or ctx is done. The error of a limit or of ctx is wrapped in the
+// *model.RuntimeError telling where the program stopped.
+func WvmContext(ctx context.Context, program *model.Program, options common.RunOptions) error {
+	_, err := NewMachine(ctx, program, options)
+	return err
+}
+
+// Assemble gives the bytecode of the code generated so far
+func (ctx *Context) Assemble() (*Bytecode, error) {
+	locs := make([]model.Locator, len(ctx.code))
+	for i, node := range ctx.nodes {
+		if node != nil {
+			locs[i] = ctx.program.Location(node)
+		}
+	}
+	bytecode, err := Assemble(ctx.code, locs, ctx.functions)
+	if err != nil {
+		return nil, err
 	}
+	bytecode.Globals, bytecode.File = ctx.nglobals, ctx.program.File
+	return bytecode, nil
 }
 
-func Wvm(program *model.Program) error {
-	wctx := NewWVMContext()
+// Compile gives the bytecode of program, to run with Exec or to write to an
+// object file
+func Compile(program *model.Program) (*Bytecode, error) {
+	_, bytecode, err := compile(program)
+	return bytecode, err
+}
+
+func compile(program *model.Program) (*Context, *Bytecode, error) {
+	wctx := NewWVMContext(program)
 	_ = InterpretNode(program.Model, wctx) // generate is InterpretNode in the same meaning
-	wvm := &WVM{
-		globals: make(map[int]interface{}),
+	wctx.NewInstruction(Instruction{"HALT", nil})
+	// calls made by Machine.Call return to this one
+	wctx.NewInstruction(Instruction{"HALT", nil})
+	bytecode, err := wctx.Assemble()
+	if err == nil {
+		err = bytecode.fit()
 	}
+	if err == nil && log.IsLevelEnabled(log.DebugLevel) {
+		log.Debug("\n" + Disassemble(bytecode, program.Source))
+	}
+	return wctx, bytecode, err
+}
 
-	//wctx.code = append(wctx.code, Instruction{"HALT", nil})
-	wctx.NewInstruction(Instruction{"HALT", nil})
-	log.Debug(wctx.code)
-	wvm.labels = wctx.labels
-	wvm.run(wctx.code)
+// Exec runs bytecode, loaded from an object file, until it halts like
+// WvmContext. host has the host functions it calls.
+func Exec(ctx context.Context, bytecode *Bytecode, host *model.Host, options common.RunOptions) error {
+	vm, err := NewWVM(ctx, bytecode, host, options)
+	if err != nil {
+		return err
+	}
+	return vm.run(0)
+}
+
+// Machine keeps the WVM of a program after its top-level statements ran,
+// its functions can then be called with Call
+type Machine struct {
+	context  *Context
+	vm       *WVM
+	bytecode *Bytecode
+}
+
+// NewMachine compiles program and runs its top-level statements like WvmContext
+func NewMachine(ctx context.Context, program *model.Program, options common.RunOptions) (*Machine, error) {
+	wctx, bytecode, err := compile(program)
+	if err != nil {
+		return nil, err
+	}
+	vm, err := NewWVM(ctx, bytecode, program.Host, options)
+	if err != nil {
+		return nil, err
+	}
+	m := &Machine{context: wctx, vm: vm, bytecode: bytecode}
+	return m, vm.run(0)
+}
 
-	return nil
+// Call calls the global function name with args, ints or float64s for the
+// checked types of the parameters, and gives its result the same way. ctx
+// and the limits of the options stop it like they stop a run.
+func (m *Machine) Call(ctx context.Context, name string, args []interface{}) (interface{}, error) {
+	fn := m.context.Lookup(name)
+	if fn == nil || fn.Scope != "" {
+		return nil, fmt.Errorf("undefined function '%s'", name)
+	}
+	decl := m.context.declarations[fn.Slot]
+	if len(args) != len(decl.Parameters) {
+		return nil, fmt.Errorf("'%s' takes %d arguments, got %d", name, len(decl.Parameters), len(args))
+	}
+	vm := m.vm
+	vm.istack, vm.fstack, vm.frames = vm.istack[:0], vm.fstack[:0], vm.frames[:0]
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case int:
+			vm.ipush(v)
+		case float64:
+			vm.fpush(v)
+		default:
+			return nil, fmt.Errorf("can't pass %T to '%s'", arg, name)
+		}
+	}
+	vm.limiter = common.NewLimiter(ctx, vm.options)
+	// the call returns to the last HALT, the stack of an error ends at the
+	// one before, given the declaration of the function called
+	halts := len(m.bytecode.starts)
+	m.bytecode.locs[halts-2] = m.context.program.Location(decl)
+	ret := m.bytecode.starts[halts-1]
+	vm.pc = ret
+	vm.call(m.bytecode.labels[fn.Slot], ret)
+	if err := vm.run(m.bytecode.labels[fn.Slot]); err != nil {
+		return nil, err
+	}
+	if fn.Type == "float" {
+		return vm.fpop(), nil
+	}
+	return vm.ipop(), nil
 }
 
 func InterpretNode(node model.Node, context *Context) string {
+	// instructions generated below come from node
+	outer := context.node
+	context.node = node
+	defer func() {
+		context.node = outer
+	}()
+
 	switch v := node.(type) {
 	case *model.Integer:
 		context.NewInstruction(Instruction{"IPUSH", v.Value})
@@ -552,103 +337,69 @@ func InterpretNode(node model.Node, context *Context) string {
 	case *model.FloatType:
 		return "float"
 	case *model.Add:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.NewInstruction(Instruction{"IADD", nil})
-			return "int"
-		} else if left == "float" && right == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.NewInstruction(Instruction{"FADD", nil})
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
-			//return &WabbitValue{"error", "type error"}
+			context.NewInstruction(Instruction{"IADD", nil})
 		}
-		return left
+		return typ
 	case *model.Mul:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.NewInstruction(Instruction{"IMUL", nil})
-			return "int"
-		} else if left == "float" && right == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.NewInstruction(Instruction{"FMUL", nil})
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
+			context.NewInstruction(Instruction{"IMUL", nil})
 		}
-		return left
+		return typ
 	case *model.Sub:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.NewInstruction(Instruction{"ISUB", nil})
-			return "int"
-		} else if left == "float" && right == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.NewInstruction(Instruction{"FSUB", nil})
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
+			context.NewInstruction(Instruction{"ISUB", nil})
 		}
-		return left
+		return typ
 	case *model.Div:
-		left := InterpretNode(v.Left, context)
-		right := InterpretNode(v.Right, context)
-
-		// we should check the type of left and right go we can't make interface + interface
-		if left == "int" && right == "int" {
-			context.NewInstruction(Instruction{"IDIV", nil})
-			return "int"
-		} else if left == "float" && right == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.NewInstruction(Instruction{"FDIV", nil})
-			return "float"
 		} else {
-			// we think it's a type error
-			panic("type different")
+			context.NewInstruction(Instruction{"IDIV", nil})
 		}
-		return left
+		return typ
 
 	case *model.Neg:
-		right := InterpretNode(v.Operand, context)
-		if right == "int" {
-			context.NewInstruction(Instruction{"INEG", nil})
-		} else if right == "float" {
+		InterpretNode(v.Operand, context)
+		typ := context.TypeOf(v)
+		if typ == "float" {
 			context.NewInstruction(Instruction{"FNEG", nil})
 		} else {
-			// we think it's a type error
-			//return &WabbitValue{"error", "type error"}
-			panic("type different")
+			context.NewInstruction(Instruction{"INEG", nil})
 		}
-		return right
+		return typ
 	case *model.Pos:
 		right := InterpretNode(v.Operand, context)
 		return right
 	case *model.Not:
-		right := InterpretNode(v.Operand, context)
-		if right == "bool" {
-			context.NewInstruction(Instruction{"IPUSH", 1})
-			context.NewInstruction(Instruction{"XOR", nil})
-		} else {
-			// we think it's a type error
-			panic("type different")
-		}
-		return right
+		InterpretNode(v.Operand, context)
+		context.NewInstruction(Instruction{"IPUSH", 1})
+		context.NewInstruction(Instruction{"XOR", nil})
+		return "bool"
 	case *model.VarDeclaration:
 		//var val *WVMVar
-		var valtype string
+		valtype := context.TypeOf(v)
 		if v.Value != nil {
-			valtype = InterpretNode(v.Value, context)
+			InterpretNode(v.Value, context)
 		} else {
-			valtype = v.Type.Type()
 			// the default value init
 			if valtype == "float" {
 				context.NewInstruction(Instruction{"FPUSH", 0.0})
@@ -674,7 +425,8 @@ func InterpretNode(node model.Node, context *Context) string {
 		return ""
 
 	case *model.ConstDeclaration:
-		valtype := InterpretNode(v.Value, context)
+		InterpretNode(v.Value, context)
+		valtype := context.TypeOf(v)
 		scope, slot := context.NewVariable()
 		context.Define(v.Name.Text, &WVMVar{Type: valtype, Scope: scope, Slot: slot}) // this is for context rember
 		if scope == "global" {
@@ -693,91 +445,57 @@ func InterpretNode(node model.Node, context *Context) string {
 		return ""
 
 	case *model.Lt:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "int" {
-			context.NewInstruction(Instruction{"ICMP", "<"})
-		} else if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.NewInstruction(Instruction{"FCMP", "<"})
-		} else if left == "char" {
-			context.NewInstruction(Instruction{"ICMP", "<"})
 		} else {
-			panic("type different")
+			context.NewInstruction(Instruction{"ICMP", "<"})
 		}
 		return "bool"
 	case *model.Le:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "int" {
-			context.NewInstruction(Instruction{"ICMP", "<="})
-		} else if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.NewInstruction(Instruction{"FCMP", "<="})
-		} else if left == "char" {
-			context.NewInstruction(Instruction{"ICMP", "<="})
-		} else if left == "bool" {
-			context.NewInstruction(Instruction{"ICMP", "<="})
 		} else {
-			panic("type different")
+			context.NewInstruction(Instruction{"ICMP", "<="})
 		}
 		return "bool"
 	case *model.Gt:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "int" {
-			context.NewInstruction(Instruction{"ICMP", ">"})
-		} else if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.NewInstruction(Instruction{"FCMP", ">"})
-		} else if left == "char" {
-			context.NewInstruction(Instruction{"ICMP", ">"})
-		} else if left == "bool" {
-			context.NewInstruction(Instruction{"ICMP", ">"})
 		} else {
-			panic("type different")
+			context.NewInstruction(Instruction{"ICMP", ">"})
 		}
 		return "bool"
 	case *model.Ge:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "int" {
-			context.NewInstruction(Instruction{"ICMP", ">="})
-		} else if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.NewInstruction(Instruction{"FCMP", ">="})
-		} else if left == "char" {
-			context.NewInstruction(Instruction{"ICMP", ">="})
-		} else if left == "bool" {
-			context.NewInstruction(Instruction{"ICMP", ">="})
 		} else {
-			panic("type different")
+			context.NewInstruction(Instruction{"ICMP", ">="})
 		}
 		return "bool"
 	case *model.Eq:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "int" {
-			context.NewInstruction(Instruction{"ICMP", "=="})
-		} else if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.NewInstruction(Instruction{"FCMP", "=="})
-		} else if left == "char" {
-			context.NewInstruction(Instruction{"ICMP", "=="})
-		} else if left == "bool" {
-			context.NewInstruction(Instruction{"ICMP", "=="})
 		} else {
-			panic("type different") // we can just using else For simple
+			context.NewInstruction(Instruction{"ICMP", "=="})
 		}
 		return "bool"
 	case *model.Ne:
-		left := InterpretNode(v.Left, context)
-		_ = InterpretNode(v.Right, context)
-		if left == "int" {
-			context.NewInstruction(Instruction{"ICMP", "!="})
-		} else if left == "float" {
+		InterpretNode(v.Left, context)
+		InterpretNode(v.Right, context)
+		if context.TypeOf(v.Left) == "float" {
 			context.NewInstruction(Instruction{"FCMP", "!="})
-		} else if left == "char" {
-			context.NewInstruction(Instruction{"ICMP", "!="})
-		} else if left == "bool" {
-			context.NewInstruction(Instruction{"ICMP", "!="})
 		} else {
-			panic("type different")
+			context.NewInstruction(Instruction{"ICMP", "!="})
 		}
 		return "bool"
 	case *model.LogOr:
@@ -833,7 +551,8 @@ func InterpretNode(node model.Node, context *Context) string {
 		return val
 
 	case *model.PrintStatement:
-		value := InterpretNode(v.Value, context)
+		InterpretNode(v.Value, context)
+		value := context.TypeOf(v.Value)
 		switch value {
 		case "char":
 			context.NewInstruction(Instruction{"PRINTC", nil})
@@ -848,19 +567,11 @@ func InterpretNode(node model.Node, context *Context) string {
 			panic("wrong type")
 		}
 	case *model.Statements:
-
-		var result string
+		// statements leave nothing on the stacks
 		for _, statement := range v.Statements {
-			// do we need pop to keep stack blance?
-			if result == "float" {
-				context.NewInstruction(Instruction{"FPOP", nil})
-			} else if result == "int" {
-				context.NewInstruction(Instruction{"IPOP", nil})
-			}
-			result = InterpretNode(statement, context)
-			// need check break return too
+			context.Discard(InterpretNode(statement, context))
 		}
-		return result
+		return ""
 
 	case *model.ExpressionAsStatement:
 		return InterpretNode(v.Expression, context)
@@ -906,14 +617,15 @@ func InterpretNode(node model.Node, context *Context) string {
 	case *model.ReturnStatement:
 
 		// 需要在这里 v.Value 的解析，你将要立马返回
-		value := InterpretNode(v.Value, context)
+		InterpretNode(v.Value, context)
 		if context.function.maybeTail &&
 			context.code[len(context.code)-1].opcode == "CALL" {
 			context.code[len(context.code)-1].opcode = "TAIL_CALL"
 		}
 
 		context.NewInstruction(Instruction{"RETURN", nil})
-		return value
+		// the value went to the caller
+		return ""
 
 	case *model.WhileStatement:
 		test_label := context.NewLabel()
@@ -949,22 +661,31 @@ func InterpretNode(node model.Node, context *Context) string {
 		context.NewInstruction(Instruction{"LABEL", start_label})
 
 		context.Define(v.Name.Text, &WVMVar{v.ReturnType.Type(), "", start_label}) //
+		f := &Func{Name: v.Name.Text, Result: v.ReturnType.Type()}
+		context.functions[start_label] = f
+		context.declarations[start_label] = v
+		// its frame has its own slots
+		nlocals := context.nlocals
+		context.nlocals = 0
 		context.NewScope(func() {
 			context.scope = "local"
 			for _, param := range v.Parameters {
 				scope, slot := context.NewVariable()
 				context.Define(param.Name.Text, &WVMVar{param.Type.Type(), scope, slot})
+				f.Params = append(f.Params, param.Type.Type())
 			}
 			for i := len(v.Parameters) - 1; i >= 0; i-- {
 				val := context.Lookup(v.Parameters[i].Name.Text)
 				if val.Type == "float" {
 					context.NewInstruction(Instruction{"FSTORE_LOCAL", val.Slot})
-				} else if val.Type == "int" {
+				} else {
+					// int, bool and char are all ints
 					context.NewInstruction(Instruction{"ISTORE_LOCAL", val.Slot})
 				}
 			}
 			InterpretNode(&v.Body, context)
 		})
+		f.Locals, context.nlocals = context.nlocals, nlocals
 		context.NewInstruction(Instruction{"LABEL", end_label})
 		context.function = oldfuc
 		context.scope = "global"
@@ -973,11 +694,11 @@ func InterpretNode(node model.Node, context *Context) string {
 		}
 
 	case *model.FunctionApplication:
-		argType := "int"
-		//value := InterpretNode(v.Func, context) // while lookup
+		argType := ""
 		//arg 如果有 call 怎么办
 		for _, arg := range v.Arguments {
-			argType = InterpretNode(arg, context) // arg eval in current context
+			InterpretNode(arg, context) // arg eval in current context
+			argType = context.TypeOf(arg)
 		}
 
 		name := v.Func.(*model.Name).Text
@@ -1002,14 +723,25 @@ func InterpretNode(node model.Node, context *Context) string {
 		if name == "bool" {
 			return "bool"
 		}
+		if host := context.program.Host.Lookup(name); host != nil {
+			context.NewInstruction(Instruction{"HOST_CALL", host.Signature()})
+			return context.TypeOf(v)
+		}
 		context.function.maybeTail = context.function.name == name
 		context.NewInstruction(Instruction{"CALL", funcVar.Slot})
-		return funcVar.Type
+		return context.TypeOf(v)
 
 	case *model.CompoundExpression:
 		var val string
 		context.NewScope(func() {
-			val = InterpretNode(&v.Statements, context)
+			// the value of the last statement is the value of the block
+			statements := v.Statements.Statements
+			for i, statement := range statements {
+				val = InterpretNode(statement, context)
+				if i < len(statements)-1 {
+					context.Discard(val)
+				}
+			}
 		})
 		return val
 	default: